@@ -0,0 +1,18 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gn
+
+import (
+	"syscall"
+)
+
+// bindCPUs 只有Linux支持sched_setaffinity
+func bindCPUs(cpus []int) error {
+	return syscall.ENOTSUP
+}
+
+// incomingCPU 只有Linux支持SO_INCOMING_CPU
+func incomingCPU(fd int) (int, error) {
+	return 0, syscall.ENOPROTOOPT
+}
@@ -0,0 +1,21 @@
+package gn
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// bindCPUs 将当前线程绑定到cpus，调用方需要先调用runtime.LockOSThread
+func bindCPUs(cpus []int) error {
+	var set unix.CPUSet
+	set.Zero()
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	// pid为0表示当前线程
+	return unix.SchedSetaffinity(0, &set)
+}
+
+// incomingCPU 获取处理该连接网络数据的CPU，即网卡RSS队列中断所在的CPU
+func incomingCPU(fd int) (int, error) {
+	return unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_INCOMING_CPU)
+}
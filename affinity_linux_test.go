@@ -0,0 +1,53 @@
+package gn
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"testing"
+	"time"
+)
+
+type affinityHandler struct {
+	results chan error
+}
+
+func (*affinityHandler) OnConnect(c *Conn) {}
+
+func (h *affinityHandler) OnMessage(c *Conn, bytes []byte) {
+	cpu, err := incomingCPU(int(c.GetFd()))
+	if err == nil && c.GetLoop() != int(c.server.loopOfCPU(cpu)) {
+		err = ErrConnClosed
+	}
+	if err == nil {
+		// OnMessage运行在绑定到CPU 0的IO goroutine上
+		var set unix.CPUSet
+		err = unix.SchedGetaffinity(0, &set)
+		if err == nil && (set.Count() != 1 || !set.IsSet(0)) {
+			err = unix.EINVAL
+		}
+	}
+	h.results <- err
+}
+
+func (*affinityHandler) OnClose(c *Conn, err error) {}
+
+func TestCPUAffinity(t *testing.T) {
+	handler := &affinityHandler{results: make(chan error, 1)}
+	_, address := startTestServer(t, handler, WithIOGNum(2), WithCPUAffinity(0), WithIncomingCPU())
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("a"))
+
+	select {
+	case err := <-handler.results:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+	}
+}
@@ -3,25 +3,83 @@ package codec
 import (
 	"errors"
 	"io"
+	"sync"
+	"sync/atomic"
 	"syscall"
 )
 
 var ErrNotEnough = errors.New("not enough")
 
 // Buffer 读缓冲区,每个tcp长连接对应一个读缓冲区
+// Read、Seek、ReadAll返回的字节数组借用缓存区的内存块，默认在下一次读取之后可能被覆盖，
+// 需要异步使用时，调用Retain持有当前内存块，Release之前这些字节数组不会被覆盖
 type Buffer struct {
-	buf   []byte // 应用内缓存区
-	start int    // 有效字节开始位置
-	end   int    // 有效字节结束位置
+	pool        *sync.Pool // 内存块的内存池，为nil时不归还
+	chunk       *chunk     // 当前内存块
+	buf         []byte     // 应用内缓存区
+	start       int        // 有效字节开始位置
+	end         int        // 有效字节结束位置
+	interrupted bool       // 是否中断了解码
+}
+
+// chunk 带引用计数的内存块，缓存区以及Retain返回的Ref各持有一个引用，为0时归还内存池
+type chunk struct {
+	buf  []byte
+	refs int32
+	pool *sync.Pool
+}
+
+func (c *chunk) release() {
+	if atomic.AddInt32(&c.refs, -1) == 0 && c.pool != nil {
+		c.pool.Put(c.buf)
+	}
+}
+
+// Ref 内存块的引用
+type Ref struct {
+	chunk    *chunk
+	released int32
+}
+
+// Release 释放引用，多次调用只会生效一次
+func (r *Ref) Release() {
+	if atomic.CompareAndSwapInt32(&r.released, 0, 1) {
+		r.chunk.release()
+	}
 }
 
 // NewBuffer 创建一个缓存区
 func NewBuffer(bytes []byte) *Buffer {
-	return &Buffer{buf: bytes, start: 0, end: 0}
+	return &Buffer{chunk: &chunk{buf: bytes, refs: 1}, buf: bytes, start: 0, end: 0}
+}
+
+// NewPooledBuffer 创建一个从内存池中获取内存块的缓存区，pool中存放固定长度的[]byte，
+// 不再使用时调用Free，内存块在所有引用释放之后归还内存池
+func NewPooledBuffer(pool *sync.Pool) *Buffer {
+	buf := pool.Get().([]byte)
+	return &Buffer{pool: pool, chunk: &chunk{buf: buf, refs: 1, pool: pool}, buf: buf}
+}
+
+// Retain 持有当前内存块，在Ref.Release之前，已经从缓存区读出的字节数组（例如OnMessage收到的bytes）不会被覆盖，
+// 用于在其他goroutine中使用这些字节数组而不需要复制
+func (b *Buffer) Retain() *Ref {
+	atomic.AddInt32(&b.chunk.refs, 1)
+	return &Ref{chunk: b.chunk}
+}
+
+// Free 释放缓存区持有的内存块，之后不能再使用缓存区
+func (b *Buffer) Free() {
+	b.chunk.release()
+	b.chunk = nil
+	b.buf = nil
+	b.start, b.end = 0, 0
 }
 
 // Len 返回有效字节数组长度
 func (b *Buffer) Len() int {
+	if b.interrupted {
+		return 0
+	}
 	return b.end - b.start
 }
 
@@ -31,6 +89,9 @@ func (b *Buffer) Cap() int {
 }
 
 func (b *Buffer) GetBytes() []byte {
+	if b.interrupted {
+		return b.buf[b.start:b.start]
+	}
 	return b.buf[b.start:b.end]
 }
 
@@ -40,9 +101,17 @@ func (b *Buffer) GetBuf() []byte {
 
 // ReadFromFD 从文件描述符里面读取数据
 func (b *Buffer) ReadFromFD(fd int) error {
-	b.reset()
+	return b.ReadFromFunc(func(p []byte) (int, error) {
+		return syscall.Read(fd, p)
+	})
+}
+
+// ReadFromFunc 使用read读取数据，read的语义和read(2)一致，例如从io_uring已经接收的数据中读取，
+// 读取到0个字节时返回syscall.EAGAIN
+func (b *Buffer) ReadFromFunc(read func(p []byte) (int, error)) error {
+	b.prepare()
 
-	n, err := syscall.Read(fd, b.buf[b.end:])
+	n, err := read(b.buf[b.end:])
 	if err != nil {
 		return err
 	}
@@ -53,9 +122,9 @@ func (b *Buffer) ReadFromFD(fd int) error {
 	return nil
 }
 
-// ReadFromReader 从reader里面读取数据，如果reader阻塞，会发生阻塞
+// ReadFromReader 从reader里面读取数据，如果reader阻塞，会发生阻塞，读取之前总是将有效字节前移，尽量多读取
 func (b *Buffer) ReadFromReader(reader io.Reader) (int, error) {
-	b.reset()
+	b.compact()
 	n, err := reader.Read(b.buf[b.end:])
 	if err != nil {
 		return n, err
@@ -66,7 +135,7 @@ func (b *Buffer) ReadFromReader(reader io.Reader) (int, error) {
 
 // Seek 返回n个字节，而不产生移位，如果没有足够字节，返回错误
 func (b *Buffer) Seek(len int) ([]byte, error) {
-	if b.end-b.start >= len {
+	if b.Len() >= len {
 		buf := b.buf[b.start : b.start+len]
 		return buf, nil
 	}
@@ -86,15 +155,58 @@ func (b *Buffer) Read(offset, limit int) ([]byte, error) {
 
 // ReadAll 读取所有字节
 func (b *Buffer) ReadAll() []byte {
-	buf, _ := b.Read(b.start, b.end)
+	buf, _ := b.Read(0, b.Len())
 	return buf
 }
 
-// reset 重新设置缓存区（将有用字节前移）
-func (b *Buffer) reset() {
+// Interrupt 中断当前的解码，之后缓存区表现为没有有效字节，解码器读取不到足够的字节而返回，直到调用Resume
+// 用于在解码过程中更换解码器，剩余的字节交给新的解码器
+func (b *Buffer) Interrupt() {
+	b.interrupted = true
+}
+
+// Interrupted 是否中断了解码
+func (b *Buffer) Interrupted() bool {
+	return b.interrupted
+}
+
+// Resume 恢复被中断的解码
+func (b *Buffer) Resume() {
+	b.interrupted = false
+}
+
+// prepare 读取之前保证尾部有空间，只有尾部没有空间时才将有效字节前移
+func (b *Buffer) prepare() {
+	if b.start == b.end && atomic.LoadInt32(&b.chunk.refs) == 1 {
+		b.start, b.end = 0, 0
+		return
+	}
+	if b.end < len(b.buf) {
+		return
+	}
+	b.compact()
+}
+
+// compact 将有效字节前移，内存块被Retain时不能覆盖已经读出的字节，将有效字节复制到新的内存块
+func (b *Buffer) compact() {
 	if b.start == 0 {
 		return
 	}
+
+	if atomic.LoadInt32(&b.chunk.refs) > 1 {
+		c := &chunk{refs: 1, pool: b.pool}
+		if b.pool != nil {
+			c.buf = b.pool.Get().([]byte)
+		} else {
+			c.buf = make([]byte, len(b.buf))
+		}
+		b.end = copy(c.buf, b.buf[b.start:b.end])
+		b.start = 0
+		b.chunk.release()
+		b.chunk = c
+		b.buf = c.buf
+		return
+	}
 	copy(b.buf, b.buf[b.start:b.end])
 	b.end -= b.start
 	b.start = 0
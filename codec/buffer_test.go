@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestBufferRetain(t *testing.T) {
+	pool := &sync.Pool{New: func() interface{} { return make([]byte, 8) }}
+	buffer := NewPooledBuffer(pool)
+	defer buffer.Free()
+
+	buffer.ReadFromReader(bytes.NewReader([]byte("abcdef")))
+	frame, _ := buffer.Read(0, 4)
+	ref := buffer.Retain()
+
+	// 被Retain的内存块不会被覆盖，有效字节复制到新的内存块
+	buffer.ReadFromReader(bytes.NewReader([]byte("ghijkl")))
+	if string(frame) != "abcd" {
+		t.Fatal(string(frame))
+	}
+	if string(buffer.GetBytes()) != "efghijkl" {
+		t.Fatal(string(buffer.GetBytes()))
+	}
+	ref.Release()
+	ref.Release()
+}
+
+func TestBufferReadFromFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	buffer := NewBuffer(make([]byte, 8))
+	read := func(s string) {
+		w.Write([]byte(s))
+		err := buffer.ReadFromFD(int(r.Fd()))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read("abcd")
+	frame, _ := buffer.Read(0, 3)
+	// 尾部有空间时不移动有效字节，借用的字节数组保持不变
+	read("efgh")
+	if string(frame) != "abc" || string(buffer.GetBytes()) != "defgh" {
+		t.Fatal(string(frame), string(buffer.GetBytes()))
+	}
+	// 尾部没有空间时才将有效字节前移
+	read("i")
+	if string(frame) != "def" || string(buffer.GetBytes()) != "defghi" {
+		t.Fatal(string(frame), string(buffer.GetBytes()))
+	}
+}
@@ -13,3 +13,15 @@ type Decoder interface {
 type Encoder interface {
 	EncodeToWriter(w io.Writer, bytes []byte) error
 }
+
+// Framer 分帧器，将一次写入的数据封装成一帧，例如WebSocket的数据帧，通过Conn.SetFramer设置之后，
+// Conn.Write、Conn.Writev写入的数据先经过Framer，返回的字节数组完成写入之前不能修改
+type Framer interface {
+	Frame(buffers [][]byte) ([][]byte, error)
+}
+
+// VectorWriter 可以一次写入多个字节数组的Writer，例如gn.Conn（使用writev）
+// 编码器写入VectorWriter时，头部和包体作为独立的字节数组写入，不需要把包体复制到写缓存区
+type VectorWriter interface {
+	Writev(buffers [][]byte) (int, error)
+}
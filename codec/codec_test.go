@@ -0,0 +1,48 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+// vectorWriter 记录每次Writev写入的字节数组数量
+type vectorWriter struct {
+	bytes.Buffer
+	vectors []int
+}
+
+func (w *vectorWriter) Writev(buffers [][]byte) (int, error) {
+	w.vectors = append(w.vectors, len(buffers))
+	n := 0
+	for _, b := range buffers {
+		m, _ := w.Write(b)
+		n += m
+	}
+	return n, nil
+}
+
+func TestEncodeToVectorWriter(t *testing.T) {
+	encoders := []Encoder{
+		NewHeaderLenEncoder(2, 1024),
+		NewUvarintEncoder(1024),
+		NewFixedLenEncoder(8, WithPadLeft()),
+	}
+	for _, encoder := range encoders {
+		w := &bytes.Buffer{}
+		vw := &vectorWriter{}
+		for _, s := range []string{"a", "hello"} {
+			if err := encoder.EncodeToWriter(w, []byte(s)); err != nil {
+				t.Fatal(err)
+			}
+			if err := encoder.EncodeToWriter(vw, []byte(s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(w.Bytes(), vw.Bytes()) {
+			t.Fatal(w.Bytes(), vw.Bytes())
+		}
+		if len(vw.vectors) != 2 || vw.vectors[0] != 2 {
+			t.Fatal(vw.vectors)
+		}
+	}
+}
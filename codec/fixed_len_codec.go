@@ -0,0 +1,136 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var ErrFrameTooLong = errors.New("frame too long")
+
+type fixedLenDecoder struct {
+	frameLen int // 每个包的固定长度
+}
+
+// NewFixedLenDecoder 创建基于固定长度的解码器
+// frameLen 每个包的固定长度，不能超过读缓存区的大小
+func NewFixedLenDecoder(frameLen int) Decoder {
+	if frameLen <= 0 {
+		panic("frameLen must greater than 0")
+	}
+
+	return &fixedLenDecoder{
+		frameLen: frameLen,
+	}
+}
+
+// Decode 解码
+func (d *fixedLenDecoder) Decode(buffer *Buffer, handle func([]byte)) error {
+	if d.frameLen > buffer.Cap() {
+		return errors.New(fmt.Sprintf("illegal frame length %d", d.frameLen))
+	}
+
+	for {
+		frame, err := buffer.Read(0, d.frameLen)
+		if err == ErrNotEnough {
+			return nil
+		}
+
+		handle(frame)
+	}
+}
+
+// FixedLenEncoderOption 固定长度编码器参数
+type FixedLenEncoderOption func(*fixedLenEncoder)
+
+// WithPadByte 设置填充字节，默认值是0
+func WithPadByte(pad byte) FixedLenEncoderOption {
+	return func(e *fixedLenEncoder) {
+		e.pad = pad
+	}
+}
+
+// WithPadLeft 在包的头部填充，默认在包的尾部填充
+func WithPadLeft() FixedLenEncoderOption {
+	return func(e *fixedLenEncoder) {
+		e.padLeft = true
+	}
+}
+
+type fixedLenEncoder struct {
+	frameLen        int        // 每个包的固定长度
+	pad             byte       // 填充字节
+	padLeft         bool       // 是否在包的头部填充
+	writeBufferPool *sync.Pool // 写缓存区内存池
+	padding         []byte     // 全部是填充字节的包，写入VectorWriter时作为填充部分，只读
+}
+
+// NewFixedLenEncoder 创建基于固定长度的编码器，不足frameLen的包会使用填充字节补齐
+// frameLen 每个包的固定长度
+func NewFixedLenEncoder(frameLen int, opts ...FixedLenEncoderOption) *fixedLenEncoder {
+	if frameLen <= 0 {
+		panic("frameLen must greater than 0")
+	}
+
+	e := &fixedLenEncoder{
+		frameLen: frameLen,
+		writeBufferPool: &sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, frameLen)
+				return b
+			},
+		},
+	}
+	for _, o := range opts {
+		o(e)
+	}
+	e.padding = make([]byte, frameLen)
+	fill(e.padding, e.pad)
+	return e
+}
+
+// EncodeToWriter 编码数据,并且写入Writer
+func (e fixedLenEncoder) EncodeToWriter(w io.Writer, bytes []byte) error {
+	l := len(bytes)
+	if l > e.frameLen {
+		return ErrFrameTooLong
+	}
+	if l == e.frameLen {
+		_, err := w.Write(bytes)
+		return err
+	}
+
+	// 包体和填充部分分开写入，避免复制包体
+	padLen := e.frameLen - l
+	if vw, ok := w.(VectorWriter); ok {
+		buffers := [][]byte{bytes, e.padding[:padLen]}
+		if e.padLeft {
+			buffers[0], buffers[1] = buffers[1], buffers[0]
+		}
+		_, err := vw.Writev(buffers)
+		return err
+	}
+
+	obj := e.writeBufferPool.Get()
+	defer e.writeBufferPool.Put(obj)
+	buffer := obj.([]byte)
+
+	// 将消息内容写入buffer，其余位置使用填充字节
+	if e.padLeft {
+		fill(buffer[:padLen], e.pad)
+		copy(buffer[padLen:], bytes)
+	} else {
+		copy(buffer, bytes)
+		fill(buffer[l:], e.pad)
+	}
+
+	_, err := w.Write(buffer)
+	return err
+}
+
+func fill(bytes []byte, b byte) {
+	for i := range bytes {
+		bytes[i] = b
+	}
+}
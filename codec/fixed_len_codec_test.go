@@ -0,0 +1,43 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_fixedLenCodec(t *testing.T) {
+	encoder := NewFixedLenEncoder(4, WithPadByte('0'), WithPadLeft())
+	w := &bytes.Buffer{}
+	for _, s := range []string{"1", "12", "1234"} {
+		err := encoder.EncodeToWriter(w, []byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.String() != "000100121234" {
+		t.Fatal(w.String())
+	}
+	if encoder.EncodeToWriter(w, []byte("12345")) != ErrFrameTooLong {
+		t.Fatal("frame too long")
+	}
+
+	buffer := NewBuffer(make([]byte, 8))
+	decoder := NewFixedLenDecoder(4)
+	var frames []string
+	handle := func(bytes []byte) {
+		frames = append(frames, string(bytes))
+	}
+	for w.Len() > 0 {
+		_, err := buffer.ReadFromReader(bytes.NewReader(w.Next(3)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = decoder.Decode(buffer, handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(frames) != 3 || frames[0] != "0001" || frames[1] != "0012" || frames[2] != "1234" {
+		t.Fatal(frames)
+	}
+}
@@ -76,6 +76,14 @@ func NewHeaderLenEncoder(headerLen, writeBufferLen int) *headerLenEncoder {
 // EncodeToWriter 编码数据,并且写入Writer
 func (e headerLenEncoder) EncodeToWriter(w io.Writer, bytes []byte) error {
 	l := len(bytes)
+	// 头部和包体分开写入，避免复制包体
+	if vw, ok := w.(VectorWriter); ok {
+		header := make([]byte, e.headerLen)
+		binary.BigEndian.PutUint16(header[0:2], uint16(l))
+		_, err := vw.Writev([][]byte{header, bytes})
+		return err
+	}
+
 	var buffer []byte
 	if l <= e.writeBufferLen-e.headerLen {
 		obj := e.writeBufferPool.Get()
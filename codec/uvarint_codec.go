@@ -73,6 +73,13 @@ func getUvarintLen(x uint64) int {
 func (e uvarintEncoder) EncodeToWriter(w io.Writer, bytes []byte) error {
 	bytesLen := uint64(len(bytes))
 	uvarintLen := getUvarintLen(bytesLen)
+	// 头部和包体分开写入，避免复制包体
+	if vw, ok := w.(VectorWriter); ok {
+		header := make([]byte, uvarintLen)
+		binary.PutUvarint(header, bytesLen)
+		_, err := vw.Writev([][]byte{header, bytes})
+		return err
+	}
 
 	var buffer []byte
 	l := uvarintLen + len(bytes)
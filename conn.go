@@ -2,6 +2,8 @@ package gn
 
 import (
 	"github.com/alberliu/gn/codec"
+	"github.com/alberliu/gn/proxyproto"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -9,12 +11,52 @@ import (
 
 // Conn 客户端长连接
 type Conn struct {
-	server *Server       // 服务器引用
-	fd     int32         // 文件描述符
-	addr   string        // 对端地址
-	buffer *codec.Buffer // 读缓存区
-	timer  *time.Timer   // 连接超时定时器
-	data   interface{}   // 业务自定义数据，用作扩展
+	server       *Server            // 服务器引用
+	fd           int32              // 文件描述符
+	addr         string             // 对端地址
+	localAddr    string             // 本端地址
+	buffer       *codec.Buffer      // 读缓存区
+	bufferRefs   int32              // 读缓存区引用计数，为0时归还内存池
+	timer        *time.Timer        // 连接超时定时器
+	closed       int32              // 连接是否已经关闭
+	closing      int32              // 是否在写缓存区写完之后关闭，由CloseAfterFlush设置
+	decoder      atomic.Value       // 解码器，类型为decoderValue
+	encoder      atomic.Value       // 编码器，类型为encoderValue
+	framer       atomic.Value       // 分帧器，类型为framerValue
+	decoderVer   int32              // 解码器版本，每次更换解码器加一
+	connected    int32              // 是否已经回调OnConnect
+	proxyPending bool               // 是否等待PROXY protocol头部
+	proxyHeader  *proxyproto.Header // PROXY protocol头部
+	limiter      atomic.Value       // 连接的限流器，类型为*rateLimiter
+	paused       int32              // 是否因为限流暂停读取
+	pollLock     sync.Mutex         // 修改监听事件的锁
+	registered   bool               // 是否已经注册到netpoll
+	pollRead     bool               // 是否监听读事件
+	pollWrite    bool               // 是否监听写事件
+	writeLock    sync.Mutex         // 写锁，保护写缓存区
+	outbound     []segment          // 写缓存区，socket缓冲区已满时未能写入的数据
+	iovecs       [][]byte           // writev使用的字节数组，复用内存
+	outboundLen  int                // 写缓存区中的字节数
+	unwritable   int32              // 写缓存区是否超过高水位
+	spliceLock   sync.Mutex         // 保护splicer
+	splicer      *splicer           // Splice创建的转发管道
+	readBytes    int                // 本次读事件读取的字节数
+	readFrames   int                // 本次读事件处理的包数
+	loop         int32              // 处理该连接的IO goroutine的序号
+	data         interface{}        // 业务自定义数据，用作扩展
+}
+
+// decoderValue、encoderValue、framerValue atomic.Value要求每次存储的类型一致，所以对接口进行包装
+type decoderValue struct {
+	codec.Decoder
+}
+
+type encoderValue struct {
+	codec.Encoder
+}
+
+type framerValue struct {
+	codec.Framer
 }
 
 // newConn 创建tcp链接
@@ -26,13 +68,29 @@ func newConn(fd int32, addr string, server *Server) *Conn {
 		})
 	}
 
-	return &Conn{
-		server: server,
-		fd:     fd,
-		addr:   addr,
-		buffer: codec.NewBuffer(server.readBufferPool.Get().([]byte)),
-		timer:  timer,
+	c := &Conn{
+		server:     server,
+		fd:         fd,
+		addr:       addr,
+		buffer:     codec.NewPooledBuffer(server.readBufferPool),
+		bufferRefs: 1,
+		timer:      timer,
+		pollRead:   true,
+	}
+	c.loop = server.selectLoop(c)
+	if sa, err := syscall.Getsockname(int(fd)); err == nil {
+		c.localAddr, _ = sockaddrString(sa)
+	}
+
+	decoder, encoder := server.options.decoder, server.options.encoder
+	if server.options.codecFactory != nil {
+		decoder, encoder = server.options.codecFactory(c)
 	}
+	c.decoder.Store(decoderValue{decoder})
+	c.encoder.Store(encoderValue{encoder})
+	c.framer.Store(framerValue{})
+	c.limiter.Store(newRateLimiter(server.options.connRateLimit))
+	return c
 }
 
 // GetFd 获取文件描述符
@@ -40,25 +98,88 @@ func (c *Conn) GetFd() int32 {
 	return c.fd
 }
 
+// GetLoop 获取处理该连接的IO goroutine的序号
+func (c *Conn) GetLoop() int {
+	return int(c.loop)
+}
+
 // GetAddr 获取客户端地址
 func (c *Conn) GetAddr() string {
 	return c.addr
 }
 
-// GetBuffer 获取客户端地址
+// LocalAddr 获取本端地址，监听多个地址时可以区分连接是从哪个地址接收的
+func (c *Conn) LocalAddr() string {
+	return c.localAddr
+}
+
+// GetProxyHeader 获取PROXY protocol头部，包含客户端真实的源地址、目的地址以及扩展字段，没有头部时返回nil
+func (c *Conn) GetProxyHeader() *proxyproto.Header {
+	return c.proxyHeader
+}
+
+// GetBuffer 获取读缓存区，在OnMessage中调用GetBuffer().Retain()可以异步使用bytes而不需要复制
 func (c *Conn) GetBuffer() *codec.Buffer {
 	return c.buffer
 }
 
+// GetDecoder 获取连接的解码器
+func (c *Conn) GetDecoder() codec.Decoder {
+	return c.decoder.Load().(decoderValue).Decoder
+}
+
+// SetDecoder 设置连接的解码器，可以在OnConnect中设置，也可以在OnMessage中设置（例如协商协议版本之后），
+// 在OnMessage中设置时，读缓存区中剩余的字节交给新的解码器解码，decoder为nil时OnMessage收到读缓存区中的所有字节
+func (c *Conn) SetDecoder(decoder codec.Decoder) {
+	c.decoder.Store(decoderValue{decoder})
+	atomic.AddInt32(&c.decoderVer, 1)
+}
+
+// GetEncoder 获取连接的编码器
+func (c *Conn) GetEncoder() codec.Encoder {
+	return c.encoder.Load().(encoderValue).Encoder
+}
+
+// SetEncoder 设置连接的编码器
+func (c *Conn) SetEncoder(encoder codec.Encoder) {
+	c.encoder.Store(encoderValue{encoder})
+}
+
+// SetFramer 设置连接的分帧器，之后Write、Writev写入的数据先封装成帧，例如WebSocket握手完成之后，
+// 协议自身的数据（例如控制帧）使用WritevRaw写入，framer为nil时不再分帧
+func (c *Conn) SetFramer(framer codec.Framer) {
+	c.framer.Store(framerValue{framer})
+}
+
 // Read 读取数据
 func (c *Conn) read() error {
+	// 读取期间持有读缓存区，防止在Handler中关闭连接之后缓存区被其他连接复用
+	if !c.acquireBuffer() {
+		return nil
+	}
+	defer c.releaseBuffer()
+
 	if c.server.options.timeout != 0 {
 		c.timer.Reset(c.server.options.timeout)
 	}
 
+	c.readBytes, c.readFrames = 0, 0
 	fd := int(c.GetFd())
-	for {
-		err := c.buffer.ReadFromFD(fd)
+	for !c.isClosing() {
+		if s := c.getSplicer(); s != nil {
+			return c.spliceRead(s)
+		}
+		if c.pauseIfLimited() {
+			return nil
+		}
+		if c.yieldIfOverBudget() {
+			return nil
+		}
+
+		before := c.buffer.Len()
+		err := c.buffer.ReadFromFunc(func(p []byte) (int, error) {
+			return c.server.netpoll.read(fd, p)
+		})
 		if err != nil {
 			// 缓存区暂无数据可读
 			if err == syscall.EAGAIN {
@@ -66,47 +187,283 @@ func (c *Conn) read() error {
 			}
 			return err
 		}
+		c.takeReadBytes(c.buffer.Len() - before)
+		c.readBytes += c.buffer.Len() - before
 
-		if c.server.options.decoder == nil {
-			c.server.handler.OnMessage(c, c.buffer.ReadAll())
-		} else {
-			var handle = func(bytes []byte) {
-				c.server.handler.OnMessage(c, bytes)
-			}
-			err = c.server.options.decoder.Decode(c.buffer, handle)
+		if c.proxyPending {
+			ok, err := c.readProxyHeader()
 			if err != nil {
 				return err
 			}
+			if !ok {
+				continue
+			}
+		}
+
+		err = c.decode()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// yieldIfOverBudget 本次读事件读取的字节数或者包数超过预算时，重新放入IO事件队列的尾部，
+// 让同一个IO goroutine上的其他连接先处理，此时socket中的数据尚未读完，边缘触发不会再产生读事件
+func (c *Conn) yieldIfOverBudget() bool {
+	options := c.server.options
+	if (options.readBudgetBytes == 0 || c.readBytes < options.readBudgetBytes) &&
+		(options.readBudgetFrames == 0 || c.readFrames < options.readBudgetFrames) {
+		return false
+	}
+	c.server.handleEvent(event{FD: c.fd, Type: EventIn})
+	return true
+}
+
+// readProxyHeader 解析PROXY protocol头部，解析完成之后回调OnConnect，头部不完整时返回false
+func (c *Conn) readProxyHeader() (bool, error) {
+	header, n, err := proxyproto.Parse(c.buffer.GetBytes())
+	if err == nil && header == nil {
+		// 头部不完整，读缓存区已满时无法再读取
+		if c.buffer.Len() < c.buffer.Cap() {
+			return false, nil
+		}
+		err = proxyproto.ErrInvalidHeader
+	}
+	if err != nil {
+		if c.server.options.proxyProtocolStrict {
+			return false, err
+		}
+		log.Debug(err)
+	} else {
+		_, _ = c.buffer.Read(0, n)
+		c.proxyHeader = header
+		if header.SrcAddr != nil {
+			c.addr = header.SrcAddr.String()
 		}
 	}
+
+	c.proxyPending = false
+	c.connect()
+	return true, nil
+}
+
+// decode 使用连接的解码器解码读缓存区，解码过程中更换了解码器时，剩余的字节交给新的解码器
+func (c *Conn) decode() error {
+	for !c.isClosing() {
+		// 调用了Splice，剩余的字节交给spliceRead转发
+		if c.getSplicer() != nil {
+			return nil
+		}
+		decoder := c.GetDecoder()
+		if decoder == nil {
+			c.handleMessage(c.buffer.ReadAll())
+			return nil
+		}
+
+		ver := atomic.LoadInt32(&c.decoderVer)
+		var handle = func(bytes []byte) {
+			c.handleMessage(bytes)
+			// 在Handler中更换了解码器，中断当前解码器
+			if atomic.LoadInt32(&c.decoderVer) != ver {
+				c.buffer.Interrupt()
+			}
+		}
+		err := decoder.Decode(c.buffer, handle)
+		if !c.buffer.Interrupted() {
+			return err
+		}
+		c.buffer.Resume()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleMessage 经过限流之后交给Handler处理
+func (c *Conn) handleMessage(bytes []byte) {
+	// 连接已经在Handler中关闭，丢弃剩余的包
+	if c.isClosing() {
+		return
+	}
+	if !c.allowMessage(bytes) {
+		return
+	}
+	c.readFrames++
+	c.server.handler.OnMessage(c, bytes)
 }
 
 // WriteWithEncoder 使用编码器写入
 func (c *Conn) WriteWithEncoder(bytes []byte) error {
-	return c.server.options.encoder.EncodeToWriter(c, bytes)
+	return c.GetEncoder().EncodeToWriter(c, bytes)
 }
 
-// Write 写入数据 todo 这里可能未能把所有数据写进去
+// Write 写入数据，socket缓冲区已满时，未写入的数据放入写缓存区，等待可写事件到来时再写入，
+// 写缓存区超过高水位时IsWritable返回false，超过WithWriteBufferLimit时以ErrWriteBufferOverflow关闭连接
 func (c *Conn) Write(bytes []byte) (int, error) {
-	return syscall.Write(int(c.fd), bytes)
+	return c.Writev([][]byte{bytes})
+}
+
+// Writev 使用writev(2)在一次系统调用中写入多个字节数组，例如头部和包体，其他行为和Write一致，
+// 设置了分帧器时，所有字节数组封装成一帧写入，返回的字节数不包含帧头部
+func (c *Conn) Writev(buffers [][]byte) (int, error) {
+	framer := c.framer.Load().(framerValue).Framer
+	if framer == nil {
+		return c.WritevRaw(buffers)
+	}
+
+	total := 0
+	for _, b := range buffers {
+		total += len(b)
+	}
+	frame, err := framer.Frame(buffers)
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.WritevRaw(frame)
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// WritevRaw 不经过分帧器写入多个字节数组，其他行为和Writev一致
+func (c *Conn) WritevRaw(buffers [][]byte) (int, error) {
+	c.writeLock.Lock()
+	if c.isClosing() {
+		c.writeLock.Unlock()
+		return 0, ErrConnClosed
+	}
+
+	total := 0
+	for _, b := range buffers {
+		total += len(b)
+	}
+	// 空数据不放入写缓存区，否则写缓存区头部的空数据会导致flush一直写入0个字节
+	if total == 0 {
+		c.writeLock.Unlock()
+		return 0, nil
+	}
+
+	n := 0
+	// 写缓存区中有数据时，需要排在后面，保证顺序，支持异步发送时先放入写缓存区再提交发送
+	if len(c.outbound) == 0 && c.server.sender == nil {
+		var err error
+		n, err = writeBuffers(int(c.fd), buffers)
+		if err != nil && err != syscall.EAGAIN {
+			c.writeLock.Unlock()
+			return n, err
+		}
+		if n == total {
+			c.writeLock.Unlock()
+			return n, nil
+		}
+	}
+
+	empty := len(c.outbound) == 0
+	overflow, changed := c.enqueue(buffers, n)
+	// 写缓存区不为空时，正在发送或者等待可写事件，之后按顺序发送
+	if c.server.sender != nil && empty && !overflow {
+		err := c.flushOutbound()
+		if err != nil && err != errSending && err != syscall.EAGAIN {
+			c.writeLock.Unlock()
+			return 0, err
+		}
+	}
+	c.writeLock.Unlock()
+
+	err := c.afterEnqueue(overflow, changed)
+	if err != nil {
+		return n, err
+	}
+	return total, nil
 }
 
-// Close 关闭连接
+// Close 关闭连接，多次调用只会生效一次，不会回调OnClose
 func (c *Conn) Close() {
-	// 从epoll监听的文件描述符中删除
+	c.close()
+}
+
+// CloseWithError 关闭连接并回调OnClose，多次调用只会生效一次，尚未回调OnConnect的连接不会回调OnClose
+func (c *Conn) CloseWithError(err error) {
+	if c.close() && atomic.LoadInt32(&c.connected) == 1 {
+		c.server.handler.OnClose(c, err)
+	}
+}
+
+// connect 回调OnConnect
+func (c *Conn) connect() {
+	atomic.StoreInt32(&c.connected, 1)
+	c.server.handler.OnConnect(c)
+}
+
+// close 关闭连接，返回是否是本次调用关闭的
+func (c *Conn) close() bool {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return false
+	}
+
+	// 从conns中删除conn，需要在关闭文件描述符之前，防止误删复用该文件描述符的新连接
+	c.server.conns.Delete(c.fd)
+	// 从epoll监听的文件描述符中删除，持有写锁，防止向复用该文件描述符的新连接写入，同时丢弃写缓存区，
+	// 持有pollLock，防止注册或者修改监听事件时操作复用该文件描述符的新连接
+	c.writeLock.Lock()
+	c.pollLock.Lock()
 	err := c.server.netpoll.closeFD(int(c.fd))
+	c.pollLock.Unlock()
+	c.discardOutbound()
+	c.writeLock.Unlock()
 	if err != nil {
 		log.Error(err)
 	}
+	// 释放转发管道
+	c.spliceLock.Lock()
+	if c.splicer != nil {
+		c.splicer.release()
+	}
+	c.spliceLock.Unlock()
 	// stop timer
-	c.timer.Stop()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
 
-	// 从conns中删除conn
-	c.server.conns.Delete(c.fd)
 	// 归还缓存区
-	c.server.readBufferPool.Put(c.buffer.GetBuf())
+	c.releaseBuffer()
 	// 连接数减一
 	atomic.AddInt64(&c.server.connsNum, -1)
+	atomic.AddInt64(&c.server.loopConns[c.loop], -1)
+	return true
+}
+
+// isClosed 连接是否已经关闭
+func (c *Conn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// isClosing 连接是否已经关闭或者调用了CloseAfterFlush，此时不再读取以及写入数据
+func (c *Conn) isClosing() bool {
+	return c.isClosed() || atomic.LoadInt32(&c.closing) == 1
+}
+
+// acquireBuffer 增加读缓存区的引用，缓存区已经归还时返回false
+func (c *Conn) acquireBuffer() bool {
+	for {
+		refs := atomic.LoadInt32(&c.bufferRefs)
+		if refs == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&c.bufferRefs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// releaseBuffer 减少读缓存区的引用，引用为0时释放读缓存区，被Retain的内存块在Release之后归还内存池
+func (c *Conn) releaseBuffer() {
+	if atomic.AddInt32(&c.bufferRefs, -1) == 0 {
+		c.buffer.Free()
+	}
 }
 
 // CloseRead 关闭连接
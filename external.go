@@ -0,0 +1,92 @@
+package gn
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"net"
+	"syscall"
+)
+
+var (
+	ErrUnsupportedConn = errors.New("conn does not support syscall.Conn")
+)
+
+// NewServerFromListener 使用已经创建的net.Listener创建server服务器，例如systemd socket activation得到的监听，
+// 复制l的文件描述符之后关闭l，之后由gn接收连接，l需要实现syscall.Conn，例如*net.TCPListener
+func NewServerFromListener(l net.Listener, handler Handler, opts ...Option) (*Server, error) {
+	options := getOptions(opts...)
+
+	listenFD, err := detachFD(l)
+	if err != nil {
+		return nil, err
+	}
+	_ = l.Close()
+	err = syscall.SetNonblock(listenFD, true)
+	if err != nil {
+		_ = syscall.Close(listenFD)
+		return nil, err
+	}
+
+	listenFDs := []int{listenFD}
+	for _, address := range options.addresses {
+		fd, err := listen(address)
+		if err != nil {
+			closeAll(listenFDs)
+			return nil, err
+		}
+		listenFDs = append(listenFDs, fd)
+	}
+	return newServer(listenFDs, handler, options)
+}
+
+// AddConn 将已经建立的连接交给Server处理，例如HTTP Upgrade之后Hijack得到的连接，
+// 复制conn的文件描述符之后关闭conn，conn中已经读取到用户态缓存的数据不会交给Server，
+// conn需要实现syscall.Conn，例如*net.TCPConn，不会解析PROXY protocol头部
+func (s *Server) AddConn(conn net.Conn) error {
+	fd, err := detachFD(conn)
+	if err != nil {
+		return err
+	}
+	_ = conn.Close()
+	return s.addConn(fd, conn.RemoteAddr().String(), false)
+}
+
+// AddConnFD 将已经建立的TCP连接的文件描述符交给Server处理，文件描述符由Server负责关闭，失败时同样会被关闭，
+// 不会解析PROXY protocol头部
+func (s *Server) AddConnFD(fd int) error {
+	sa, err := syscall.Getpeername(fd)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return err
+	}
+	addr, err := sockaddrString(sa)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return err
+	}
+	syscall.CloseOnExec(fd)
+	return s.addConn(fd, addr, false)
+}
+
+// detachFD 复制Go标准库中连接或者监听的文件描述符，复制的文件描述符不受Go runtime netpoller管理，
+// 不使用File()，防止文件描述符被设置为阻塞状态
+func detachFD(c interface{}) (int, error) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return 0, ErrUnsupportedConn
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var nfd int
+	var dupErr error
+	err = rc.Control(func(fd uintptr) {
+		nfd, dupErr = unix.FcntlInt(fd, unix.F_DUPFD_CLOEXEC, 0)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return nfd, dupErr
+}
@@ -3,6 +3,8 @@ module github.com/alberliu/gn
 go 1.12
 
 require (
+	github.com/vmihailenco/msgpack/v5 v5.3.4
 	go.uber.org/zap v1.21.0
 	golang.org/x/sys v0.0.0-20210510120138-977fb7262007
+	google.golang.org/protobuf v1.27.1
 )
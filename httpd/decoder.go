@@ -0,0 +1,169 @@
+package httpd
+
+import (
+	"bytes"
+	"errors"
+	"github.com/alberliu/gn/codec"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrRequestTooLarge = errors.New("http request too large")
+	ErrHeaderTooLarge  = errors.New("http request header too large")
+	ErrBadRequest      = errors.New("http bad request")
+)
+
+var (
+	crlf      = []byte("\r\n")
+	headerEnd = []byte("\r\n\r\n")
+)
+
+type decoder struct{}
+
+// NewDecoder 创建HTTP/1.1请求解码器，每次回调一个完整的请求（请求行、请求头以及请求体）
+// 支持Content-Length以及chunked请求体，支持流水线（pipelining），请求不能超过读缓存区的大小
+// 请求头非法或者超过读缓存区时，剩余的字节交给handle，由NewHandler返回400或者431并关闭连接
+func NewDecoder() codec.Decoder {
+	return &decoder{}
+}
+
+// Decode 解码
+func (d *decoder) Decode(buffer *codec.Buffer, handle func([]byte)) error {
+	for {
+		n, err := scanRequest(buffer.GetBytes())
+		if err == ErrBadRequest {
+			handle(buffer.ReadAll())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			if buffer.Len() < buffer.Cap() {
+				return nil
+			}
+			// 请求头不完整时，ParseRequest返回ErrHeaderTooLarge
+			if bytes.Index(buffer.GetBytes(), headerEnd) < 0 {
+				handle(buffer.ReadAll())
+				return nil
+			}
+			return ErrRequestTooLarge
+		}
+
+		request, err := buffer.Read(0, n)
+		if err != nil {
+			return nil
+		}
+		handle(request)
+	}
+}
+
+// scanRequest 返回第一个完整请求的字节数，请求不完整时返回0
+func scanRequest(buf []byte) (int, error) {
+	end := bytes.Index(buf, headerEnd)
+	if end < 0 {
+		return 0, nil
+	}
+	bodyStart := end + len(headerEnd)
+
+	contentLength, chunked, err := scanHeader(buf[:end])
+	if err != nil {
+		return 0, err
+	}
+	if chunked {
+		n, _, err := readChunked(buf[bodyStart:], false)
+		if err != nil || n == 0 {
+			return 0, err
+		}
+		return bodyStart + n, nil
+	}
+
+	if len(buf) < bodyStart+contentLength {
+		return 0, nil
+	}
+	return bodyStart + contentLength, nil
+}
+
+// scanHeader 从请求头中获取请求体的长度以及是否是chunked编码
+// 参考RFC 9112 6.3，同时存在Content-Length以及Transfer-Encoding，或者存在多个Content-Length时，返回ErrBadRequest
+func scanHeader(header []byte) (contentLength int, chunked bool, err error) {
+	hasLength := false
+	for _, line := range bytes.Split(header, crlf)[1:] {
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 {
+			return 0, false, ErrBadRequest
+		}
+		key := string(bytes.TrimSpace(line[:i]))
+		value := string(bytes.TrimSpace(line[i+1:]))
+
+		switch {
+		case strings.EqualFold(key, "Content-Length"):
+			if hasLength {
+				return 0, false, ErrBadRequest
+			}
+			hasLength = true
+			contentLength, err = strconv.Atoi(value)
+			if err != nil || contentLength < 0 {
+				return 0, false, ErrBadRequest
+			}
+		case strings.EqualFold(key, "Transfer-Encoding"):
+			if !strings.EqualFold(value, "chunked") {
+				return 0, false, ErrBadRequest
+			}
+			chunked = true
+		}
+	}
+	if hasLength && chunked {
+		return 0, false, ErrBadRequest
+	}
+	return contentLength, chunked, nil
+}
+
+// readChunked 解析chunked请求体，返回请求体的字节数（包括结尾的trailer），不完整时返回0
+// decode为true时同时返回解码后的内容
+func readChunked(buf []byte, decode bool) (int, []byte, error) {
+	var body []byte
+	offset := 0
+	for {
+		lineEnd := bytes.Index(buf[offset:], crlf)
+		if lineEnd < 0 {
+			return 0, nil, nil
+		}
+		line := buf[offset : offset+lineEnd]
+		// 忽略chunk扩展
+		if i := bytes.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		size, err := strconv.ParseInt(string(bytes.TrimSpace(line)), 16, 32)
+		if err != nil || size < 0 {
+			return 0, nil, ErrBadRequest
+		}
+		offset += lineEnd + len(crlf)
+
+		if size == 0 {
+			// 跳过trailer，直到空行
+			for {
+				lineEnd = bytes.Index(buf[offset:], crlf)
+				if lineEnd < 0 {
+					return 0, nil, nil
+				}
+				offset += lineEnd + len(crlf)
+				if lineEnd == 0 {
+					return offset, body, nil
+				}
+			}
+		}
+
+		if len(buf) < offset+int(size)+len(crlf) {
+			return 0, nil, nil
+		}
+		if !bytes.Equal(buf[offset+int(size):offset+int(size)+len(crlf)], crlf) {
+			return 0, nil, ErrBadRequest
+		}
+		if decode {
+			body = append(body, buf[offset:offset+int(size)]...)
+		}
+		offset += int(size) + len(crlf)
+	}
+}
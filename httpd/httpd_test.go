@@ -0,0 +1,181 @@
+package httpd
+
+import (
+	"bytes"
+	"github.com/alberliu/gn"
+	"github.com/alberliu/gn/codec"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecoder(t *testing.T) {
+	raw := "GET /a HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello" +
+		"POST /c HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3;ext\r\nhel\r\n2\r\nlo\r\n0\r\nTrailer: x\r\n\r\n"
+
+	buffer := codec.NewBuffer(make([]byte, 1024))
+	decoder := NewDecoder()
+	var requests []*Request
+	handle := func(bytes []byte) {
+		r, err := ParseRequest(bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 请求体引用读缓存区，需要制作拷贝
+		r.Body = append([]byte(nil), r.Body...)
+		requests = append(requests, r)
+	}
+
+	reader := strings.NewReader(raw)
+	for reader.Len() > 0 {
+		_, err := buffer.ReadFromReader(&limitReader{reader: reader, n: 7})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = decoder.Decode(buffer, handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(requests) != 3 {
+		t.Fatal(len(requests))
+	}
+	if requests[0].Path != "/a" || requests[0].Header.Get("Host") != "x" || requests[0].Close {
+		t.Fatal(requests[0])
+	}
+	if string(requests[1].Body) != "hello" || string(requests[2].Body) != "hello" {
+		t.Fatal(string(requests[1].Body), string(requests[2].Body))
+	}
+}
+
+type limitReader struct {
+	reader *strings.Reader
+	n      int
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	return r.reader.Read(p)
+}
+
+func TestServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	mux := NewServeMux()
+	mux.HandleFunc(http.MethodGet, "/health", func(w *ResponseWriter, r *Request) {
+		w.WriteString("ok")
+	})
+	mux.HandleFunc(http.MethodGet, "/large", func(w *ResponseWriter, r *Request) {
+		w.Write(make([]byte, 8<<20))
+	})
+	mux.HandleFunc(http.MethodPost, "/echo/", func(w *ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(r.Body)
+	})
+	server, err := gn.NewServer(address, NewHandler(mux), gn.WithDecoder(NewDecoder()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Run()
+
+	get := func(url string) (int, string) {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := get("http://" + address + "/health"); status != 200 || body != "ok" {
+		t.Fatal(status, body)
+	}
+	if status, _ := get("http://" + address + "/none"); status != 404 {
+		t.Fatal(status)
+	}
+	if status, _ := get("http://" + address + "/echo/x"); status != 405 {
+		t.Fatal(status)
+	}
+
+	resp, err := http.Post("http://"+address+"/echo/x", "text/plain", bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" || resp.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Fatal(string(body), resp.Header)
+	}
+
+	// Connection: close时，超过socket缓冲区的响应写完之后才关闭连接
+	req, _ := http.NewRequest(http.MethodGet, "http://"+address+"/large", nil)
+	req.Close = true
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(body) != 8<<20 {
+		t.Fatal(len(body), err)
+	}
+}
+
+func TestServer_badRequest(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	handler := HandlerFunc(func(w *ResponseWriter, r *Request) {
+		w.WriteString("ok")
+	})
+	server, err := gn.NewServer(address, NewHandler(handler), gn.WithDecoder(NewDecoder()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Run()
+
+	tests := []struct {
+		raw    string
+		status string
+	}{
+		// 同时存在Content-Length以及Transfer-Encoding
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", "HTTP/1.1 400 "},
+		// 重复的Content-Length
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello", "HTTP/1.1 400 "},
+		// 冲突的Content-Length
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 3\r\n\r\nhello", "HTTP/1.1 400 "},
+		// 请求头超过读缓存区
+		{"GET / HTTP/1.1\r\nCookie: " + strings.Repeat("a", 2048) + "\r\n\r\n", "HTTP/1.1 431 "},
+	}
+	for _, test := range tests {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte(test.raw))
+		// 响应之后关闭连接，请求中尚未读取的字节可能导致RST，只检查关闭之前收到的响应
+		resp, err := ioutil.ReadAll(conn)
+		conn.Close()
+		if e, ok := err.(net.Error); (ok && e.Timeout()) || !strings.HasPrefix(string(resp), test.status) {
+			t.Fatal(string(resp), err)
+		}
+	}
+}
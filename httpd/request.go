@@ -0,0 +1,78 @@
+package httpd
+
+import (
+	"bytes"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// Request HTTP请求
+type Request struct {
+	Method string      // 请求方法
+	URI    string      // 请求行中的URI
+	Path   string      // 请求路径
+	Query  url.Values  // 查询参数
+	Proto  string      // 协议版本，HTTP/1.1或者HTTP/1.0
+	Header http.Header // 请求头
+	Body   []byte      // 请求体，chunked请求体已经解码，只在ServeHTTP期间有效
+	Close  bool        // 响应之后是否关闭连接
+}
+
+// ParseRequest 解析一个完整的请求，通常是解码器的输出，请求头不完整时返回ErrHeaderTooLarge
+func ParseRequest(raw []byte) (*Request, error) {
+	end := bytes.Index(raw, headerEnd)
+	if end < 0 {
+		return nil, ErrHeaderTooLarge
+	}
+	_, chunked, err := scanHeader(raw[:end])
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(raw[:end]), "\r\n")
+	parts := strings.Split(lines[0], " ")
+	if len(parts) != 3 || (parts[2] != "HTTP/1.1" && parts[2] != "HTTP/1.0") {
+		return nil, ErrBadRequest
+	}
+	u, err := url.ParseRequestURI(parts[1])
+	if err != nil {
+		return nil, ErrBadRequest
+	}
+
+	r := &Request{
+		Method: parts[0],
+		URI:    parts[1],
+		Path:   u.Path,
+		Query:  u.Query(),
+		Proto:  parts[2],
+		Header: make(http.Header, len(lines)-1),
+	}
+	for _, line := range lines[1:] {
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, ErrBadRequest
+		}
+		key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(line[:i]))
+		r.Header[key] = append(r.Header[key], strings.TrimSpace(line[i+1:]))
+	}
+
+	body := raw[end+len(headerEnd):]
+	if chunked {
+		_, body, err = readChunked(body, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.Body = body
+
+	// HTTP/1.1默认保持连接，HTTP/1.0默认关闭连接
+	connection := r.Header.Get("Connection")
+	if r.Proto == "HTTP/1.1" {
+		r.Close = strings.EqualFold(connection, "close")
+	} else {
+		r.Close = !strings.EqualFold(connection, "keep-alive")
+	}
+	return r, nil
+}
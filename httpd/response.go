@@ -0,0 +1,74 @@
+package httpd
+
+import (
+	"bytes"
+	"github.com/alberliu/gn"
+	"net/http"
+	"strconv"
+)
+
+// ResponseWriter 响应，ServeHTTP返回之后一次性写入连接
+type ResponseWriter struct {
+	conn   *gn.Conn
+	req    *Request
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+// Conn 返回请求所在的连接
+func (w *ResponseWriter) Conn() *gn.Conn {
+	return w.conn
+}
+
+// Header 返回响应头
+func (w *ResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader 设置响应状态码，默认值是200
+func (w *ResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+// Write 写入响应体
+func (w *ResponseWriter) Write(bytes []byte) (int, error) {
+	return w.body.Write(bytes)
+}
+
+// WriteString 写入响应体
+func (w *ResponseWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// bytes 生成完整的响应
+func (w *ResponseWriter) bytes() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(w.req.Proto)
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.Itoa(w.status))
+	buf.WriteByte(' ')
+	buf.WriteString(http.StatusText(w.status))
+	buf.WriteString("\r\n")
+
+	// 1xx、204、304响应没有响应体
+	bodyAllowed := w.status >= 200 && w.status != http.StatusNoContent && w.status != http.StatusNotModified
+	if bodyAllowed {
+		w.header.Set("Content-Length", strconv.Itoa(w.body.Len()))
+		if w.body.Len() > 0 && w.header.Get("Content-Type") == "" {
+			w.header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+	}
+	if w.req.Close {
+		w.header.Set("Connection", "close")
+	} else if w.req.Proto == "HTTP/1.0" {
+		w.header.Set("Connection", "keep-alive")
+	}
+	w.header.Write(buf)
+	buf.WriteString("\r\n")
+
+	if bodyAllowed && w.req.Method != http.MethodHead {
+		buf.Write(w.body.Bytes())
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,134 @@
+package httpd
+
+import (
+	"github.com/alberliu/gn"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var log = gn.GetLogger()
+
+// Handler HTTP请求处理接口
+type Handler interface {
+	ServeHTTP(w *ResponseWriter, r *Request)
+}
+
+// HandlerFunc HTTP请求处理函数
+type HandlerFunc func(w *ResponseWriter, r *Request)
+
+// ServeHTTP 调用f(w, r)
+func (f HandlerFunc) ServeHTTP(w *ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// NewHandler 将HTTP Handler转换为gn.Handler，需要配合gn.WithDecoder(httpd.NewDecoder())使用
+func NewHandler(handler Handler) gn.Handler {
+	return &connHandler{handler: handler}
+}
+
+type connHandler struct {
+	handler Handler
+}
+
+func (h *connHandler) OnConnect(c *gn.Conn) {}
+
+// OnMessage 处理一个完整的请求，流水线中的请求按顺序响应
+func (h *connHandler) OnMessage(c *gn.Conn, bytes []byte) {
+	r, err := ParseRequest(bytes)
+	if err != nil {
+		log.Debug(err)
+		status := "400 Bad Request"
+		if err == ErrHeaderTooLarge {
+			status = "431 Request Header Fields Too Large"
+		}
+		_, _ = c.Write([]byte("HTTP/1.1 " + status + "\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"))
+		c.CloseAfterFlush()
+		return
+	}
+
+	w := &ResponseWriter{
+		conn:   c,
+		req:    r,
+		status: http.StatusOK,
+		header: make(http.Header),
+	}
+	h.handler.ServeHTTP(w, r)
+
+	_, err = c.Write(w.bytes())
+	// 响应可能还在写缓存区中，写完之后再关闭连接
+	if err != nil || r.Close {
+		c.CloseAfterFlush()
+	}
+}
+
+func (h *connHandler) OnClose(c *gn.Conn, err error) {}
+
+type route struct {
+	method  string
+	path    string
+	handler Handler
+}
+
+// ServeMux 路由，根据请求方法以及路径分发请求
+// 路径以"/"结尾时按前缀匹配，否则精确匹配，前缀匹配时最长的路径优先
+type ServeMux struct {
+	lock   sync.RWMutex
+	routes []route
+}
+
+// NewServeMux 创建路由
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// Handle 注册请求处理，method为空时匹配所有请求方法
+func (m *ServeMux) Handle(method, path string, handler Handler) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.routes = append(m.routes, route{method: method, path: path, handler: handler})
+}
+
+// HandleFunc 注册请求处理函数，method为空时匹配所有请求方法
+func (m *ServeMux) HandleFunc(method, path string, handler func(w *ResponseWriter, r *Request)) {
+	m.Handle(method, path, HandlerFunc(handler))
+}
+
+// ServeHTTP 分发请求，没有匹配的路径返回404，路径匹配但方法不匹配返回405
+func (m *ServeMux) ServeHTTP(w *ResponseWriter, r *Request) {
+	m.lock.RLock()
+	var matched *route
+	pathMatched := false
+	for i := range m.routes {
+		rt := &m.routes[i]
+		if !matchPath(rt.path, r.Path) {
+			continue
+		}
+		pathMatched = true
+		if rt.method != "" && rt.method != r.Method {
+			continue
+		}
+		if matched == nil || len(rt.path) > len(matched.path) {
+			matched = rt
+		}
+	}
+	m.lock.RUnlock()
+
+	if matched == nil {
+		if pathMatched {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = w.WriteString(http.StatusText(w.status))
+		return
+	}
+	matched.handler.ServeHTTP(w, r)
+}
+
+func matchPath(pattern, path string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(path, pattern)
+	}
+	return pattern == path
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket 令牌桶，并发安全
+type Bucket struct {
+	lock   sync.Mutex
+	rate   float64   // 每秒生成的令牌数
+	burst  float64   // 桶的容量
+	tokens float64   // 当前令牌数，可以为负数，表示透支
+	last   time.Time // 上次计算令牌的时间
+}
+
+// NewBucket 创建令牌桶，初始时桶是满的
+// rate 每秒生成的令牌数
+// burst 桶的容量
+func NewBucket(rate float64, burst int) *Bucket {
+	if rate <= 0 || burst <= 0 {
+		panic("rate or burst must greater than 0")
+	}
+
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// SetLimit 调整生成速率以及桶的容量
+func (b *Bucket) SetLimit(rate float64, burst int) {
+	if rate <= 0 || burst <= 0 {
+		panic("rate or burst must greater than 0")
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	b.rate = rate
+	b.burst = float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Allow 令牌足够时取走n个令牌并返回true，否则返回false，
+// n超过桶的容量时，桶满即可取走，之后透支
+func (b *Bucket) Allow(n int) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	if !b.ready(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// Ready 返回令牌是否足够取走n个令牌，不取走令牌，同时检查多个令牌桶时先全部检查再取走
+func (b *Bucket) Ready(n int) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	return b.ready(n)
+}
+
+func (b *Bucket) ready(n int) bool {
+	return b.tokens >= math.Min(float64(n), b.burst)
+}
+
+// Take 取走n个令牌，令牌不足时透支
+func (b *Bucket) Take(n int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+}
+
+// Delay 返回令牌数恢复到至少1个还需要等待的时间
+func (b *Bucket) Delay() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *Bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last)
+	if elapsed <= 0 {
+		return
+	}
+	b.last = now
+	b.tokens += elapsed.Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(100, 10)
+	if !b.Allow(10) {
+		t.Fatal("burst not allowed")
+	}
+	if b.Allow(1) {
+		t.Fatal("empty bucket allowed")
+	}
+
+	if b.Ready(1) {
+		t.Fatal("empty bucket ready")
+	}
+
+	b.Take(10)
+	delay := b.Delay()
+	if delay < 100*time.Millisecond || delay > 110*time.Millisecond {
+		t.Fatal(delay)
+	}
+
+	time.Sleep(delay + time.Millisecond)
+	if b.Delay() != 0 {
+		t.Fatal("bucket not refilled")
+	}
+}
+
+func TestBucketOversize(t *testing.T) {
+	b := NewBucket(100, 10)
+	// 超过容量时，桶满即可取走，之后透支
+	if !b.Ready(20) || !b.Allow(20) {
+		t.Fatal("oversize not allowed")
+	}
+	if b.Ready(1) || b.Allow(20) {
+		t.Fatal("oversize allowed while in debt")
+	}
+	if delay := b.Delay(); delay < 100*time.Millisecond {
+		t.Fatal(delay)
+	}
+}
@@ -0,0 +1,125 @@
+package gn
+
+import (
+	"sync"
+)
+
+// IOQueueStats IO事件队列的统计数据，用于观察队列是否饱和
+type IOQueueStats struct {
+	Pending    int   // 当前待处理的事件数
+	MaxPending int   // 待处理事件数的最大值
+	Events     int64 // 累计写入的事件数
+	Batches    int64 // 消费者累计取出的批次数
+	Saturated  int64 // 待处理事件数超过WithIOEventQueueLen的次数
+}
+
+// ioQueue IO事件队列，多个生产者写入，一个消费者每次取出所有待处理的事件，写入不会阻塞，
+// 某个消费者处理缓慢时，不会阻塞生产者以及其他队列
+type ioQueue struct {
+	lock       sync.Mutex
+	events     []event       // 待处理的事件
+	closed     bool          // 队列是否已经关闭
+	signal     chan struct{} // 有新事件时通知消费者，容量为1
+	limit      int           // 饱和阈值
+	maxPending int           // 待处理事件数的最大值
+	eventsNum  int64         // 累计写入的事件数
+	batches    int64         // 消费者累计取出的批次数
+	saturated  int64         // 待处理事件数超过limit的次数
+	local      []event       // 生产者在一次wait中收集的事件，只在生产者goroutine中使用
+}
+
+func newIOQueue(limit int) *ioQueue {
+	return &ioQueue{
+		events: make([]event, 0, limit),
+		signal: make(chan struct{}, 1),
+		limit:  limit,
+	}
+}
+
+// push 写入一批事件，加锁一次，通知一次消费者
+func (q *ioQueue) push(events ...event) {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return
+	}
+	before := len(q.events)
+	q.events = append(q.events, events...)
+	after := len(q.events)
+	if after > q.maxPending {
+		q.maxPending = after
+	}
+	if before <= q.limit && after > q.limit {
+		q.saturated++
+	}
+	q.eventsNum += int64(len(events))
+	q.lock.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// flushLocal 将生产者在一次wait中收集的事件写入队列
+func (q *ioQueue) flushLocal() {
+	if len(q.local) == 0 {
+		return
+	}
+	q.push(q.local...)
+	q.local = q.local[:0]
+}
+
+// pop 取出所有待处理的事件，没有事件时阻塞，buf是上一次取出的切片，用于复用内存，队列关闭时返回false
+func (q *ioQueue) pop(buf []event) ([]event, bool) {
+	for {
+		q.lock.Lock()
+		if len(q.events) > 0 {
+			events := q.events
+			q.events = buf[:0]
+			q.batches++
+			q.lock.Unlock()
+			return events, true
+		}
+		if q.closed {
+			q.lock.Unlock()
+			return nil, false
+		}
+		q.lock.Unlock()
+		<-q.signal
+	}
+}
+
+// close 关闭队列，消费者处理完剩余的事件之后退出
+func (q *ioQueue) close() {
+	q.lock.Lock()
+	q.closed = true
+	q.lock.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// stats 获取统计数据
+func (q *ioQueue) stats() IOQueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return IOQueueStats{
+		Pending:    len(q.events),
+		MaxPending: q.maxPending,
+		Events:     q.eventsNum,
+		Batches:    q.batches,
+		Saturated:  q.saturated,
+	}
+}
+
+// GetIOQueueStats 获取每个IO事件队列的统计数据
+func (s *Server) GetIOQueueStats() []IOQueueStats {
+	stats := make([]IOQueueStats, len(s.ioQueues))
+	for i, q := range s.ioQueues {
+		stats[i] = q.stats()
+	}
+	return stats
+}
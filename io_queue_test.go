@@ -0,0 +1,118 @@
+package gn
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIOQueue(t *testing.T) {
+	q := newIOQueue(2)
+	q.local = append(q.local, event{FD: 1}, event{FD: 2}, event{FD: 3})
+	q.flushLocal()
+	q.push(event{FD: 4})
+
+	events, ok := q.pop(nil)
+	if !ok || len(events) != 4 || events[3].FD != 4 {
+		t.Fatal(events)
+	}
+	stats := q.stats()
+	if stats.Pending != 0 || stats.MaxPending != 4 || stats.Events != 4 || stats.Batches != 1 || stats.Saturated != 1 {
+		t.Fatal(stats)
+	}
+
+	q.close()
+	if _, ok := q.pop(events); ok {
+		t.Fatal("queue closed")
+	}
+}
+
+const (
+	benchShards    = 4
+	benchWaitBatch = 64 // 模拟每次wait返回的事件数量
+)
+
+// benchDispatch 模拟生产者在每次wait之后分发事件，统计事件从分发到被消费的延迟
+func benchDispatch(b *testing.B, dispatch func(e event), flush func(), consume func(shard int, handle func(e event))) {
+	sent := make([]time.Time, b.N)
+	latencies := make([][]time.Duration, benchShards)
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	for i := 0; i < benchShards; i++ {
+		i := i
+		go consume(i, func(e event) {
+			latencies[i] = append(latencies[i], time.Since(sent[e.FD]))
+			wg.Done()
+		})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sent[i] = time.Now()
+		dispatch(event{FD: int32(i), Type: EventIn})
+		if i%benchWaitBatch == benchWaitBatch-1 {
+			flush()
+		}
+	}
+	flush()
+	wg.Wait()
+	b.StopTimer()
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	b.ReportMetric(float64(all[len(all)*99/100]), "p99-ns")
+}
+
+// BenchmarkChanDispatch 原来的实现，每个事件发送一次channel
+func BenchmarkChanDispatch(b *testing.B) {
+	queues := make([]chan event, benchShards)
+	for i := range queues {
+		queues[i] = make(chan event, 1024)
+	}
+	benchDispatch(b, func(e event) {
+		queues[int(e.FD)%benchShards] <- e
+	}, func() {}, func(shard int, handle func(e event)) {
+		for e := range queues[shard] {
+			handle(e)
+		}
+	})
+	for _, q := range queues {
+		close(q)
+	}
+}
+
+// BenchmarkQueueDispatch 每次wait之后按照队列批量写入
+func BenchmarkQueueDispatch(b *testing.B) {
+	queues := make([]*ioQueue, benchShards)
+	for i := range queues {
+		queues[i] = newIOQueue(1024)
+	}
+	benchDispatch(b, func(e event) {
+		q := queues[int(e.FD)%benchShards]
+		q.local = append(q.local, e)
+	}, func() {
+		for _, q := range queues {
+			q.flushLocal()
+		}
+	}, func(shard int, handle func(e event)) {
+		var buf []event
+		for {
+			events, ok := queues[shard].pop(buf)
+			if !ok {
+				return
+			}
+			for i := range events {
+				handle(events[i])
+			}
+			buf = events
+		}
+	})
+	for _, q := range queues {
+		q.close()
+	}
+}
@@ -0,0 +1,162 @@
+package gn
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// acceptTimeout 没有新连接时accept等待的时间，超时之后accept goroutine检查是否需要停止接收连接
+const acceptTimeout = 100 * time.Millisecond
+
+// listenAll 为每个地址创建监听的文件描述符，从父进程继承了监听的文件描述符或者由systemd socket activation传递了监听的文件描述符时直接使用，
+// names用于按照LISTEN_FDNAMES选择systemd传递的文件描述符，监听的文件描述符是非阻塞的
+func listenAll(addresses []string, names []string) ([]int, error) {
+	listenFDs, ok, err := inheritedListeners()
+	if err != nil || ok {
+		return listenFDs, err
+	}
+	listenFDs, ok, err = activationListeners(names)
+	if err != nil || ok {
+		return listenFDs, err
+	}
+
+	for _, address := range addresses {
+		listenFD, err := listen(address)
+		if err != nil {
+			closeAll(listenFDs)
+			return nil, err
+		}
+		listenFDs = append(listenFDs, listenFD)
+	}
+	return listenFDs, nil
+}
+
+// listen 创建监听的文件描述符，IPv6地址只接收IPv6连接，可以和相同端口的IPv4地址同时监听
+func listen(address string) (int, error) {
+	family, sa, err := resolveSockaddr(address)
+	if err != nil {
+		return 0, err
+	}
+
+	listenFD, err := syscall.Socket(family, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		log.Error(err)
+		return 0, err
+	}
+	syscall.CloseOnExec(listenFD)
+	err = syscall.SetsockoptInt(listenFD, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	if err != nil {
+		log.Error(err)
+		_ = syscall.Close(listenFD)
+		return 0, err
+	}
+	if family == syscall.AF_INET6 {
+		err = syscall.SetsockoptInt(listenFD, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 1)
+		if err != nil {
+			log.Error(err)
+			_ = syscall.Close(listenFD)
+			return 0, err
+		}
+	}
+
+	err = syscall.Bind(listenFD, sa)
+	if err != nil {
+		log.Error(err)
+		_ = syscall.Close(listenFD)
+		return 0, err
+	}
+	err = syscall.Listen(listenFD, 1024)
+	if err != nil {
+		log.Error(err)
+		_ = syscall.Close(listenFD)
+		return 0, err
+	}
+	err = syscall.SetNonblock(listenFD, true)
+	if err != nil {
+		log.Error(err)
+		_ = syscall.Close(listenFD)
+		return 0, err
+	}
+	return listenFD, nil
+}
+
+// inheritedListeners 获取通过环境变量EnvListenFD从父进程继承的监听文件描述符，多个文件描述符以逗号分隔，获取之后清除环境变量
+func inheritedListeners() ([]int, bool, error) {
+	value := os.Getenv(EnvListenFD)
+	if value == "" {
+		return nil, false, nil
+	}
+	_ = os.Unsetenv(EnvListenFD)
+
+	var listenFDs []int
+	for _, s := range strings.Split(value, ",") {
+		listenFD, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, false, err
+		}
+		syscall.CloseOnExec(listenFD)
+		err = syscall.SetNonblock(listenFD, true)
+		if err != nil {
+			return nil, false, err
+		}
+		listenFDs = append(listenFDs, listenFD)
+	}
+	log.Info("inherit listeners from parent process, fds: ", value)
+	return listenFDs, true, nil
+}
+
+// closeAll 关闭所有文件描述符
+func closeAll(fds []int) {
+	for _, fd := range fds {
+		_ = syscall.Close(fd)
+	}
+}
+
+// acceptor 从多个非阻塞的监听文件描述符接收连接
+type acceptor struct {
+	fds   []int         // 监听的文件描述符
+	polls []unix.PollFd // poll使用的参数，只读
+	next  uint32        // 下一次优先接收的文件描述符，轮流接收，防止某个地址的连接饿死
+}
+
+func newAcceptor(fds []int) *acceptor {
+	polls := make([]unix.PollFd, len(fds))
+	for i, fd := range fds {
+		polls[i] = unix.PollFd{Fd: int32(fd), Events: unix.POLLIN}
+	}
+	return &acceptor{fds: fds, polls: polls}
+}
+
+// accept 接收连接，没有新连接时最多等待acceptTimeout，超时返回EAGAIN
+func (a *acceptor) accept() (int, syscall.Sockaddr, error) {
+	nfd, sa, err := a.tryAccept()
+	if err != syscall.EAGAIN {
+		return nfd, sa, err
+	}
+
+	// poll会修改revents，每次使用副本，多个accept goroutine可以同时调用
+	polls := make([]unix.PollFd, len(a.polls))
+	copy(polls, a.polls)
+	_, err = unix.Poll(polls, int(acceptTimeout/time.Millisecond))
+	if err != nil && err != unix.EINTR {
+		return 0, nil, err
+	}
+	return a.tryAccept()
+}
+
+// tryAccept 依次尝试从每个监听的文件描述符接收连接，都没有新连接时返回EAGAIN
+func (a *acceptor) tryAccept() (int, syscall.Sockaddr, error) {
+	start := int(atomic.AddUint32(&a.next, 1))
+	for i := range a.fds {
+		nfd, sa, err := syscall.Accept(a.fds[(start+i)%len(a.fds)])
+		if err != syscall.EAGAIN {
+			return nfd, sa, err
+		}
+	}
+	return 0, nil, syscall.EAGAIN
+}
@@ -0,0 +1,113 @@
+package gn
+
+import (
+	"hash/fnv"
+	"net"
+	"runtime"
+	"sync/atomic"
+)
+
+// LoadBalance 连接建立时选择IO goroutine的策略
+type LoadBalance int
+
+const (
+	LoadBalanceFD         LoadBalance = iota // 按照文件描述符取模，默认
+	LoadBalanceRoundRobin                    // 轮询
+	LoadBalanceLeastConns                    // 选择当前连接数最少的IO goroutine
+	LoadBalanceSourceHash                    // 按照客户端IP的哈希，同一个IP的连接由同一个IO goroutine处理
+)
+
+// LoadBalanceFunc 自定义的负载均衡策略，loopConns是每个IO goroutine当前的连接数，返回IO goroutine的序号
+type LoadBalanceFunc func(c *Conn, loopConns []int64) int
+
+// lockThread 开启WithLockOSThread或者WithCPUAffinity时，将当前goroutine锁定到线程，并绑定到cpus
+func (s *Server) lockThread(cpus []int) {
+	if !s.options.lockOSThread {
+		return
+	}
+	runtime.LockOSThread()
+	if len(cpus) == 0 {
+		return
+	}
+	err := bindCPUs(cpus)
+	if err != nil {
+		log.Error("bind cpu error: ", err)
+	}
+}
+
+// loopCPUs 获取第index个IO goroutine绑定的CPU，没有设置WithCPUAffinity时返回nil
+func (s *Server) loopCPUs(index int) []int {
+	cpus := s.options.cpus
+	if len(cpus) == 0 {
+		return nil
+	}
+	return []int{cpus[index%len(cpus)]}
+}
+
+// selectLoop 连接建立时选择处理该连接的IO goroutine，并增加该IO goroutine的连接数，
+// 开启WithIncomingCPU时，优先选择绑定在接收该连接数据的CPU上的IO goroutine，否则使用负载均衡策略
+func (s *Server) selectLoop(c *Conn) int32 {
+	loop := s.balance(c)
+	atomic.AddInt64(&s.loopConns[loop], 1)
+	return loop
+}
+
+func (s *Server) balance(c *Conn) int32 {
+	if s.options.incomingCPU {
+		cpu, err := incomingCPU(int(c.fd))
+		if err == nil {
+			return s.loopOfCPU(cpu)
+		}
+		log.Debug("get incoming cpu error: ", err)
+	}
+
+	if s.options.loadBalanceFunc != nil {
+		loop := s.options.loadBalanceFunc(c, s.GetLoopConnsNum()) % int(s.ioQueueNum)
+		if loop < 0 {
+			loop += int(s.ioQueueNum)
+		}
+		return int32(loop)
+	}
+
+	switch s.options.loadBalance {
+	case LoadBalanceRoundRobin:
+		return int32(atomic.AddUint32(&s.nextLoop, 1) % uint32(s.ioQueueNum))
+	case LoadBalanceLeastConns:
+		var loop int32
+		for i := range s.loopConns {
+			if atomic.LoadInt64(&s.loopConns[i]) < atomic.LoadInt64(&s.loopConns[loop]) {
+				loop = int32(i)
+			}
+		}
+		return loop
+	case LoadBalanceSourceHash:
+		ip, _, err := net.SplitHostPort(c.addr)
+		if err != nil {
+			ip = c.addr
+		}
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(ip))
+		return int32(h.Sum32() % uint32(s.ioQueueNum))
+	}
+	return c.fd % s.ioQueueNum
+}
+
+// GetLoopConnsNum 获取每个IO goroutine当前的连接数
+func (s *Server) GetLoopConnsNum() []int64 {
+	nums := make([]int64, len(s.loopConns))
+	for i := range s.loopConns {
+		nums[i] = atomic.LoadInt64(&s.loopConns[i])
+	}
+	return nums
+}
+
+// loopOfCPU 获取绑定在cpu上的IO goroutine，没有设置WithCPUAffinity时，按照cpu取模
+func (s *Server) loopOfCPU(cpu int) int32 {
+	cpus := s.options.cpus
+	for i := 0; i < int(s.ioQueueNum); i++ {
+		if len(cpus) > 0 && cpus[i%len(cpus)] == cpu {
+			return int32(i)
+		}
+	}
+	return int32(cpu) % s.ioQueueNum
+}
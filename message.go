@@ -0,0 +1,63 @@
+package gn
+
+import (
+	"errors"
+)
+
+var ErrMessageCodecNil = errors.New("message codec is nil")
+
+// MessageCodec 消息编解码器，负责业务消息和包体之间的相互转换
+type MessageCodec interface {
+	Marshal(msg interface{}) ([]byte, error)     // Marshal 将业务消息转换为包体
+	Unmarshal(bytes []byte) (interface{}, error) // Unmarshal 将包体转换为业务消息
+}
+
+// TypedHandler 类型化消息处理接口，需要配合WithMessageCodec使用
+type TypedHandler interface {
+	OnConnect(c *Conn)                       // OnConnect 当TCP长连接建立成功是回调
+	OnTypedMessage(c *Conn, msg interface{}) // OnTypedMessage 当客户端有消息写入是回调
+	OnClose(c *Conn, err error)              // OnClose 当客户端主动断开链接或者超时时回调,err返回关闭的原因
+}
+
+// NewTypedHandler 将TypedHandler转换为Handler
+func NewTypedHandler(handler TypedHandler) Handler {
+	return &typedHandler{TypedHandler: handler}
+}
+
+type typedHandler struct {
+	TypedHandler
+}
+
+// OnMessage 使用消息编解码器解析包体，无法解析的包会被丢弃
+func (h *typedHandler) OnMessage(c *Conn, bytes []byte) {
+	messageCodec := c.server.options.messageCodec
+	if messageCodec == nil {
+		log.Error(ErrMessageCodecNil)
+		return
+	}
+
+	msg, err := messageCodec.Unmarshal(bytes)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	h.OnTypedMessage(c, msg)
+}
+
+// Send 使用消息编解码器序列化消息，再使用编码器写入
+func (c *Conn) Send(msg interface{}) error {
+	messageCodec := c.server.options.messageCodec
+	if messageCodec == nil {
+		return ErrMessageCodecNil
+	}
+
+	bytes, err := messageCodec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if c.GetEncoder() == nil {
+		_, err = c.Write(bytes)
+		return err
+	}
+	return c.WriteWithEncoder(bytes)
+}
@@ -0,0 +1,67 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const idLen = 2 // 消息类型ID的字节长度
+
+var ErrIllegalMessage = errors.New("illegal message")
+
+// Codec 类型化消息编解码器，实现了gn.MessageCodec
+// 包体格式：2字节消息类型ID（大端序）+ 序列化后的消息
+type Codec struct {
+	registry   *Registry  // 消息类型注册表
+	serializer Serializer // 序列化器
+}
+
+// NewCodec 创建类型化消息编解码器
+func NewCodec(registry *Registry, serializer Serializer) *Codec {
+	if registry == nil || serializer == nil {
+		panic("registry or serializer must not be nil")
+	}
+
+	return &Codec{
+		registry:   registry,
+		serializer: serializer,
+	}
+}
+
+// Marshal 将消息转换为包体
+func (c *Codec) Marshal(msg interface{}) ([]byte, error) {
+	id, ok := c.registry.GetID(msg)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("message type %T not registered", msg))
+	}
+
+	data, err := c.serializer.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes := make([]byte, idLen+len(data))
+	binary.BigEndian.PutUint16(bytes, id)
+	copy(bytes[idLen:], data)
+	return bytes, nil
+}
+
+// Unmarshal 将包体转换为消息，返回的消息是注册时的指针类型
+func (c *Codec) Unmarshal(bytes []byte) (interface{}, error) {
+	if len(bytes) < idLen {
+		return nil, ErrIllegalMessage
+	}
+
+	id := binary.BigEndian.Uint16(bytes)
+	msg, ok := c.registry.New(id)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("message id %d not registered", id))
+	}
+
+	err := c.serializer.Unmarshal(bytes[idLen:], msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
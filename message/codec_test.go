@@ -0,0 +1,65 @@
+package message
+
+import (
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"testing"
+)
+
+type loginReq struct {
+	UserID int64
+	Token  string
+}
+
+type loginResp struct {
+	Code int
+}
+
+func TestCodec(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(1, &loginReq{})
+	registry.Register(2, &loginResp{})
+
+	for _, serializer := range []Serializer{JSON, Msgpack} {
+		codec := NewCodec(registry, serializer)
+
+		bytes, err := codec.Marshal(&loginReq{UserID: 1, Token: "token"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := codec.Unmarshal(bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, ok := msg.(*loginReq)
+		if !ok || req.UserID != 1 || req.Token != "token" {
+			t.Fatal(msg)
+		}
+
+		_, err = codec.Marshal(&struct{}{})
+		if err == nil {
+			t.Fatal("unregistered message marshaled")
+		}
+		_, err = codec.Unmarshal([]byte{0, 3})
+		if err == nil {
+			t.Fatal("unregistered message unmarshaled")
+		}
+	}
+}
+
+func TestCodecProto(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(1, &wrapperspb.StringValue{})
+	codec := NewCodec(registry, Proto)
+
+	bytes, err := codec.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := codec.Unmarshal(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.(*wrapperspb.StringValue).GetValue() != "hello" {
+		t.Fatal(msg)
+	}
+}
@@ -0,0 +1,62 @@
+package message
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Registry 消息类型注册表，维护消息类型ID和Go类型的对应关系
+type Registry struct {
+	lock  sync.RWMutex
+	types map[uint16]reflect.Type // 消息类型ID到Go类型
+	ids   map[reflect.Type]uint16 // Go类型到消息类型ID
+}
+
+// NewRegistry 创建消息类型注册表
+func NewRegistry() *Registry {
+	return &Registry{
+		types: make(map[uint16]reflect.Type),
+		ids:   make(map[reflect.Type]uint16),
+	}
+}
+
+// Register 注册消息类型，msg必须是结构体指针，例如 &LoginReq{}
+func (r *Registry) Register(id uint16, msg interface{}) {
+	t := reflect.TypeOf(msg)
+	if t == nil || t.Kind() != reflect.Ptr {
+		panic("msg must be a pointer")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.types[id]; ok {
+		panic(fmt.Sprintf("message id %d registered twice", id))
+	}
+	if _, ok := r.ids[t]; ok {
+		panic(fmt.Sprintf("message type %s registered twice", t))
+	}
+	r.types[id] = t
+	r.ids[t] = id
+}
+
+// GetID 获取消息对应的消息类型ID
+func (r *Registry) GetID(msg interface{}) (uint16, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	id, ok := r.ids[reflect.TypeOf(msg)]
+	return id, ok
+}
+
+// New 根据消息类型ID创建一个新的消息
+func (r *Registry) New(id uint16) (interface{}, bool) {
+	r.lock.RLock()
+	t, ok := r.types[id]
+	r.lock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return reflect.New(t.Elem()).Interface(), true
+}
@@ -0,0 +1,60 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrNotProtoMessage = errors.New("not proto message")
+
+// Serializer 序列化器，负责业务消息和字节数组之间的相互转换
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSON    Serializer = jsonSerializer{}    // JSON 使用encoding/json序列化
+	Proto   Serializer = protoSerializer{}   // Proto 使用protobuf序列化，消息需要实现proto.Message
+	Msgpack Serializer = msgpackSerializer{} // Msgpack 使用msgpack序列化
+)
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type protoSerializer struct{}
+
+func (protoSerializer) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(msg)
+}
+
+func (protoSerializer) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackSerializer) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
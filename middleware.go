@@ -0,0 +1,67 @@
+package gn
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+var ErrHandlerPanic = errors.New("handler panic")
+
+// Middleware 中间件，对Handler进行包装，可以拦截OnConnect、OnMessage、OnClose
+type Middleware func(next Handler) Handler
+
+// chainMiddlewares 使用中间件包装handler，先添加的中间件在外层
+func chainMiddlewares(handler Handler, middlewares []Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Recovery 捕获Handler中的panic，打印堆栈，并以ErrHandlerPanic关闭发生panic的连接，不影响其他连接
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return &recoveryHandler{next: next}
+	}
+}
+
+type recoveryHandler struct {
+	next Handler
+}
+
+func (h *recoveryHandler) OnConnect(c *Conn) {
+	defer h.recover(c, true)
+	h.next.OnConnect(c)
+}
+
+func (h *recoveryHandler) OnMessage(c *Conn, bytes []byte) {
+	defer h.recover(c, true)
+	h.next.OnMessage(c, bytes)
+}
+
+func (h *recoveryHandler) OnClose(c *Conn, err error) {
+	defer h.recover(c, false)
+	h.next.OnClose(c, err)
+}
+
+// OnWritabilityChanged next实现了WritabilityHandler时转发
+func (h *recoveryHandler) OnWritabilityChanged(c *Conn, writable bool) {
+	if next, ok := h.next.(WritabilityHandler); ok {
+		defer h.recover(c, true)
+		next.OnWritabilityChanged(c, writable)
+	}
+}
+
+// recover 捕获panic，close为true时关闭连接
+func (h *recoveryHandler) recover(c *Conn, close bool) {
+	err := recover()
+	if err == nil {
+		return
+	}
+
+	log.Error(fmt.Sprintf("fd:%d handler panic: %v\n%s", c.GetFd(), err, debug.Stack()))
+	if close {
+		c.CloseWithError(ErrHandlerPanic)
+	}
+}
@@ -0,0 +1,86 @@
+package mux
+
+import (
+	"bytes"
+)
+
+// MatchResult 协议识别结果
+type MatchResult int
+
+const (
+	NoMatch  MatchResult = iota // 不是该协议
+	Match                       // 是该协议
+	NeedMore                    // 需要更多字节才能判断
+)
+
+// MatchFunc 根据连接的起始字节识别协议
+type MatchFunc func(head []byte) MatchResult
+
+// Prefix 以prefix开头的连接
+func Prefix(prefix []byte) MatchFunc {
+	return func(head []byte) MatchResult {
+		if len(head) < len(prefix) {
+			if bytes.HasPrefix(prefix, head) {
+				return NeedMore
+			}
+			return NoMatch
+		}
+		if bytes.HasPrefix(head, prefix) {
+			return Match
+		}
+		return NoMatch
+	}
+}
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+	[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "),
+}
+
+// HTTP 以HTTP请求方法开头的连接
+func HTTP() MatchFunc {
+	return func(head []byte) MatchResult {
+		result := NoMatch
+		for _, method := range httpMethods {
+			switch Prefix(method)(head) {
+			case Match:
+				return Match
+			case NeedMore:
+				result = NeedMore
+			}
+		}
+		return result
+	}
+}
+
+const maxUpgradeHeaderLen = 8192
+
+// WebSocket 带有Upgrade: websocket请求头的HTTP GET请求，需要读取完整的请求头才能判断，需要在HTTP之前注册
+func WebSocket() MatchFunc {
+	get := Prefix([]byte("GET "))
+	return func(head []byte) MatchResult {
+		result := get(head)
+		if result != Match {
+			return result
+		}
+
+		end := bytes.Index(head, []byte("\r\n\r\n"))
+		if end < 0 {
+			if len(head) > maxUpgradeHeaderLen {
+				return NoMatch
+			}
+			return NeedMore
+		}
+		for _, line := range bytes.Split(head[:end], []byte("\r\n"))[1:] {
+			i := bytes.IndexByte(line, ':')
+			if i <= 0 {
+				continue
+			}
+			if bytes.EqualFold(bytes.TrimSpace(line[:i]), []byte("Upgrade")) &&
+				bytes.EqualFold(bytes.TrimSpace(line[i+1:]), []byte("websocket")) {
+				return Match
+			}
+		}
+		return NoMatch
+	}
+}
@@ -0,0 +1,276 @@
+package mux
+
+import (
+	"errors"
+	"github.com/alberliu/gn"
+	"github.com/alberliu/gn/codec"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownProtocol = errors.New("unknown protocol")
+	ErrDetectTimeout   = errors.New("protocol detect timeout")
+)
+
+// Protocol 注册到Mux的协议
+type Protocol struct {
+	Name    string        // 协议名称
+	Match   MatchFunc     // 协议识别函数
+	Decoder codec.Decoder // 解码器，为nil时OnMessage收到读缓存区中的所有字节
+	Encoder codec.Encoder // 编码器，为nil时直接写入
+	Handler gn.Handler    // 协议处理
+}
+
+// Option Mux参数
+type Option func(*Mux)
+
+// WithDetectTimeout 设置协议识别的超时时间，超时之后使用默认协议，没有默认协议时以ErrDetectTimeout关闭连接
+func WithDetectTimeout(timeout time.Duration) Option {
+	return func(m *Mux) {
+		if timeout <= 0 {
+			panic("detectTimeout must greater than 0")
+		}
+		m.detectTimeout = timeout
+	}
+}
+
+// WithDefault 设置默认协议，没有协议匹配或者识别超时的时候使用，适用于服务端先发送数据的协议
+func WithDefault(p *Protocol) Option {
+	return func(m *Mux) {
+		m.fallback = p
+	}
+}
+
+// connState 每个连接的协议识别状态
+type connState struct {
+	lock      sync.Mutex
+	protocol  *Protocol     // 识别出的协议，识别之后在连接的生命周期内不再改变
+	decoder   codec.Decoder // 协议的Handler在OnConnect中设置的解码器，为nil时使用协议的解码器
+	connected bool          // 是否已经回调协议的OnConnect
+	timer     *time.Timer   // 协议识别超时定时器
+}
+
+// Mux 在一个端口上同时提供多种协议，根据连接的起始字节识别协议，实现了gn.Handler
+// Mux在OnConnect中为每个连接设置解码器，连接状态保存在解码器中，随连接一起释放，需要配合gn.WithEncoder(m.Encoder())使用
+// 协议的Handler可以在OnConnect中设置自己的解码器（例如websocket.Handler），之后由Mux的解码器代理
+type Mux struct {
+	protocols     []*Protocol   // 按注册顺序识别的协议
+	fallback      *Protocol     // 默认协议
+	detectTimeout time.Duration // 协议识别超时时间
+}
+
+// New 创建Mux
+func New(opts ...Option) *Mux {
+	m := &Mux{
+		detectTimeout: 5 * time.Second,
+	}
+	for _, o := range opts {
+		o(m)
+	}
+	return m
+}
+
+// Register 注册协议，按注册顺序识别
+func (m *Mux) Register(p *Protocol) {
+	if p.Match == nil || p.Handler == nil {
+		panic("protocol match and handler must not be nil")
+	}
+	m.protocols = append(m.protocols, p)
+}
+
+// Protocol 获取连接识别出的协议，尚未识别时返回nil
+func (m *Mux) Protocol(c *gn.Conn) *Protocol {
+	st := stateOf(c)
+	if st == nil {
+		return nil
+	}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return st.protocol
+}
+
+// Decoder 返回Mux的解码器，Mux在OnConnect中为每个连接设置解码器，不再需要gn.WithDecoder(m.Decoder())，保留用于兼容
+func (m *Mux) Decoder() codec.Decoder {
+	return &decoder{mux: m}
+}
+
+// Encoder 返回使用连接对应协议编码器的编码器
+func (m *Mux) Encoder() codec.Encoder {
+	return &encoder{mux: m}
+}
+
+// OnConnect 当TCP长连接建立成功是回调，设置连接的解码器，识别出协议之后才会回调协议的OnConnect
+func (m *Mux) OnConnect(c *gn.Conn) {
+	d := &decoder{mux: m, conn: c, state: &connState{}}
+	c.SetDecoder(d)
+
+	st := d.state
+	st.lock.Lock()
+	st.timer = time.AfterFunc(m.detectTimeout, d.detectTimeoutExpired)
+	st.lock.Unlock()
+}
+
+// OnMessage 交给连接对应协议的Handler处理
+func (m *Mux) OnMessage(c *gn.Conn, bytes []byte) {
+	p := m.Protocol(c)
+	if p == nil {
+		return
+	}
+	p.Handler.OnMessage(c, bytes)
+}
+
+// OnClose 当客户端主动断开链接或者超时时回调
+func (m *Mux) OnClose(c *gn.Conn, err error) {
+	st := stateOf(c)
+	if st == nil {
+		return
+	}
+
+	st.lock.Lock()
+	if st.timer != nil {
+		st.timer.Stop()
+	}
+	p := st.protocol
+	connected := st.connected
+	st.connected = false
+	st.lock.Unlock()
+
+	if p != nil && connected {
+		p.Handler.OnClose(c, err)
+	}
+}
+
+// stateOf 获取连接状态，连接的解码器不是Mux的解码器时返回nil
+func stateOf(c *gn.Conn) *connState {
+	d, ok := c.GetDecoder().(*decoder)
+	if !ok {
+		return nil
+	}
+	return d.state
+}
+
+// detect 根据读缓存区中的字节识别协议，需要更多字节时返回nil
+func (m *Mux) detect(buffer *codec.Buffer) (*Protocol, error) {
+	head, err := buffer.Seek(buffer.Len())
+	if err != nil || len(head) == 0 {
+		return nil, nil
+	}
+
+	for _, p := range m.protocols {
+		switch p.Match(head) {
+		case Match:
+			return p, nil
+		case NeedMore:
+			// 读缓存区已满时无法获得更多字节
+			if buffer.Len() < buffer.Cap() {
+				return nil, nil
+			}
+		}
+	}
+	if m.fallback != nil {
+		return m.fallback, nil
+	}
+	return nil, ErrUnknownProtocol
+}
+
+// decoder 每个连接的解码器，保存连接的协议识别状态
+type decoder struct {
+	mux   *Mux
+	conn  *gn.Conn
+	state *connState
+}
+
+// detectTimeoutExpired 协议识别超时
+func (d *decoder) detectTimeoutExpired() {
+	st := d.state
+	st.lock.Lock()
+	if st.protocol != nil || d.mux.fallback == nil {
+		pinned := st.protocol != nil
+		st.lock.Unlock()
+		if !pinned {
+			d.conn.CloseWithError(ErrDetectTimeout)
+		}
+		return
+	}
+	st.protocol = d.mux.fallback
+	st.connected = true
+	st.lock.Unlock()
+
+	d.connect(d.mux.fallback)
+}
+
+// connect 回调协议的OnConnect，协议的Handler设置的解码器由Mux的解码器代理，保证连接状态可以找到
+func (d *decoder) connect(p *Protocol) {
+	p.Handler.OnConnect(d.conn)
+
+	decoder := d.conn.GetDecoder()
+	if decoder == codec.Decoder(d) {
+		return
+	}
+	d.state.lock.Lock()
+	d.state.decoder = decoder
+	d.state.lock.Unlock()
+	d.conn.SetDecoder(d)
+}
+
+// Decode 尚未识别协议时先识别协议，识别之后回调协议的OnConnect，并使用协议的解码器解码
+func (d *decoder) Decode(buffer *codec.Buffer, handle func([]byte)) error {
+	st := d.state
+	if st == nil {
+		return nil
+	}
+
+	st.lock.Lock()
+	p := st.protocol
+	if p == nil {
+		var err error
+		p, err = d.mux.detect(buffer)
+		if err != nil || p == nil {
+			st.lock.Unlock()
+			return err
+		}
+		st.protocol = p
+		st.connected = true
+		if st.timer != nil {
+			st.timer.Stop()
+		}
+		st.lock.Unlock()
+		d.connect(p)
+		st.lock.Lock()
+	}
+	decoder := st.decoder
+	st.lock.Unlock()
+
+	if decoder == nil {
+		decoder = p.Decoder
+	}
+	if decoder == nil {
+		handle(buffer.ReadAll())
+		return nil
+	}
+	return decoder.Decode(buffer, handle)
+}
+
+type encoder struct {
+	mux *Mux
+}
+
+// EncodeToWriter 使用连接对应协议的编码器编码，Writer必须是*gn.Conn
+func (e *encoder) EncodeToWriter(w io.Writer, bytes []byte) error {
+	c, ok := w.(*gn.Conn)
+	if !ok {
+		return errors.New("mux encoder writer must be *gn.Conn")
+	}
+
+	p := e.mux.Protocol(c)
+	if p == nil {
+		return ErrUnknownProtocol
+	}
+	if p.Encoder == nil {
+		_, err := c.Write(bytes)
+		return err
+	}
+	return p.Encoder.EncodeToWriter(c, bytes)
+}
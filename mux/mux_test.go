@@ -0,0 +1,163 @@
+package mux
+
+import (
+	"bufio"
+	"github.com/alberliu/gn"
+	"github.com/alberliu/gn/codec"
+	"github.com/alberliu/gn/httpd"
+	"github.com/alberliu/gn/websocket"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMatcher(t *testing.T) {
+	ws := WebSocket()
+	if ws([]byte("GE")) != NeedMore || ws([]byte("GET / HTTP/1.1\r\n")) != NeedMore {
+		t.Fatal("need more")
+	}
+	if ws([]byte("GET / HTTP/1.1\r\nUpgrade: websocket\r\n\r\n")) != Match {
+		t.Fatal("websocket not match")
+	}
+	if ws([]byte("GET / HTTP/1.1\r\n\r\n")) != NoMatch || ws([]byte("POST")) != NoMatch {
+		t.Fatal("http match websocket")
+	}
+	if HTTP()([]byte("DEL")) != NeedMore || HTTP()([]byte("DELETE /")) != Match || HTTP()([]byte("\x00")) != NoMatch {
+		t.Fatal("http")
+	}
+}
+
+type echoHandler struct{}
+
+func (echoHandler) OnConnect(c *gn.Conn) {}
+
+func (echoHandler) OnMessage(c *gn.Conn, bytes []byte) {
+	c.WriteWithEncoder(bytes)
+}
+
+func (echoHandler) OnClose(c *gn.Conn, err error) {}
+
+func TestMux(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	m := New(WithDetectTimeout(100 * time.Millisecond))
+	m.Register(&Protocol{
+		Name:    "http",
+		Match:   HTTP(),
+		Decoder: httpd.NewDecoder(),
+		Handler: httpd.NewHandler(httpd.HandlerFunc(func(w *httpd.ResponseWriter, r *httpd.Request) {
+			w.WriteString("ok")
+		})),
+	})
+	m.Register(&Protocol{
+		Name:    "fixed",
+		Match:   Prefix([]byte("GN")),
+		Decoder: codec.NewFixedLenDecoder(4),
+		Encoder: codec.NewFixedLenEncoder(4),
+		Handler: echoHandler{},
+	})
+	server, err := gn.NewServer(address, m, gn.WithDecoder(m.Decoder()), gn.WithEncoder(m.Encoder()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Run()
+
+	resp, err := http.Get("http://" + address + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatal(string(body))
+	}
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("GN01GN02"))
+	buf := make([]byte, 8)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "GN01GN02" {
+		t.Fatal(string(buf), err)
+	}
+
+	// 未发送数据的连接在识别超时之后被关闭
+	idle, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	idle.SetDeadline(time.Now().Add(time.Second))
+	_, err = idle.Read(buf)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("idle conn not closed")
+	}
+}
+
+type wsHandler struct {
+	mux *Mux
+}
+
+func (wsHandler) OnConnect(c *gn.Conn) {}
+
+func (h wsHandler) OnMessage(c *gn.Conn, bytes []byte) {
+	c.Write([]byte(h.mux.Protocol(c).Name + ":" + string(bytes)))
+}
+
+func (wsHandler) OnClose(c *gn.Conn, err error) {}
+
+func TestMux_websocket(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	// websocket.Handler在OnConnect中设置自己的解码器，由Mux的解码器代理
+	m := New()
+	m.Register(&Protocol{
+		Name:    "websocket",
+		Match:   WebSocket(),
+		Handler: websocket.NewHandler(wsHandler{mux: m}),
+	})
+	server, err := gn.NewServer(address, m, gn.WithEncoder(m.Encoder()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Run()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	// 带掩码的"hi"
+	conn.Write([]byte{0x81, 0x82, 1, 2, 3, 4, 'h' ^ 1, 'i' ^ 2})
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal(resp, err)
+	}
+	buf := make([]byte, 14)
+	_, err = io.ReadFull(reader, buf)
+	if err != nil || string(buf) != "\x82\x0cwebsocket:hi" {
+		t.Fatal(buf, err)
+	}
+}
@@ -6,7 +6,7 @@
 package gn
 
 import (
-	"fmt"
+	"sync"
 	"syscall"
 )
 
@@ -16,42 +16,18 @@ const (
 )
 
 type epoll struct {
-	listenFD int
-	epollFD  int
-	ts       syscall.Timespec
-	changes  []syscall.Kevent_t
+	acceptor  *acceptor
+	epollFD   int
+	ts        syscall.Timespec
+	lock      sync.Mutex
+	changes   []syscall.Kevent_t
+	deletes   []syscall.Kevent_t // 只需要提交一次的删除操作
+	changeBuf []syscall.Kevent_t // 复用的提交数组，只在wait中使用
+	events    []syscall.Kevent_t // 复用的事件数组，只在wait中使用
 }
 
-func newNetpoll(address string) (netpoll, error) {
-	listenFD, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
-	if err != nil {
-		log.Error(err)
-		return nil, err
-	}
-	err = syscall.SetsockoptInt(listenFD, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
-	if err != nil {
-		log.Error(err)
-		return nil, err
-	}
-
-	addr, port, err := getIPPort(address)
-	if err != nil {
-		return nil, err
-	}
-	err = syscall.Bind(listenFD, &syscall.SockaddrInet4{
-		Port: port,
-		Addr: addr,
-	})
-	if err != nil {
-		log.Error(err)
-		return nil, err
-	}
-	err = syscall.Listen(listenFD, 1024)
-	if err != nil {
-		log.Error(err)
-		return nil, err
-	}
-
+// newNetpoll 使用监听的文件描述符创建netpoll，创建失败时由调用方关闭监听的文件描述符
+func newNetpoll(listenFDs []int, options *options) (netpoll, error) {
 	// !!
 	// mac epollFD
 	epollFD, err := syscall.Kqueue()
@@ -69,35 +45,88 @@ func newNetpoll(address string) (netpoll, error) {
 	//!!
 
 	return &epoll{
-		listenFD: listenFD,
+		acceptor: newAcceptor(listenFDs),
 		epollFD:  epollFD,
 		ts:       syscall.NsecToTimespec(1e9),
+		events:   make([]syscall.Kevent_t, options.pollBatchSize),
 	}, nil
 }
 
 func (n *epoll) accept() (nfd int, addr string, err error) {
-	nfd, sa, err := syscall.Accept(n.listenFD)
+	nfd, sa, err := n.acceptor.accept()
 	if err != nil {
 		return
 	}
 
-	//设置为非阻塞状态
-	err = syscall.SetNonblock(nfd, true)
+	addr, err = sockaddrString(sa)
 	if err != nil {
-		return
+		_ = syscall.Close(nfd)
 	}
+	return
+}
+
+// stopAccept 连接由accept goroutine主动接收，停止accept goroutine之后未接收的连接留在监听的队列中
+func (n *epoll) stopAccept() {}
 
+func (n *epoll) listeners() []int {
+	return n.acceptor.fds
+}
+
+func (n *epoll) add(fd int) error {
+	n.lock.Lock()
 	n.changes = append(n.changes, syscall.Kevent_t{
-		Ident: uint64(nfd), Flags: EpollRead, Filter: syscall.EVFILT_READ,
+		Ident: uint64(fd), Flags: EpollRead, Filter: syscall.EVFILT_READ,
 	})
+	n.lock.Unlock()
+	return nil
+}
 
-	s := sa.(*syscall.SockaddrInet4)
-	addr = fmt.Sprintf("%d.%d.%d.%d:%d", s.Addr[0], s.Addr[1], s.Addr[2], s.Addr[3], s.Port)
-	return
+func (n *epoll) modify(fd int, read, write bool) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	ident := uint64(fd)
+	found := false
+	changes := n.changes[:0]
+	for _, ke := range n.changes {
+		if ke.Ident != ident {
+			changes = append(changes, ke)
+			continue
+		}
+		// 写事件在下面重新添加
+		if ke.Filter == syscall.EVFILT_WRITE {
+			continue
+		}
+		found = true
+		if read {
+			ke.Flags = EpollRead | syscall.EV_ENABLE
+		} else {
+			ke.Flags = EpollRead | syscall.EV_DISABLE
+		}
+		changes = append(changes, ke)
+	}
+	n.changes = changes
+	if !found {
+		return syscall.ENOENT
+	}
+
+	if write {
+		n.changes = append(n.changes, syscall.Kevent_t{
+			Ident: ident, Flags: syscall.EV_ADD | syscall.EV_CLEAR, Filter: syscall.EVFILT_WRITE,
+		})
+	} else {
+		// changes每次都会重新提交，删除写事件只需要提交一次
+		n.deletes = append(n.deletes, syscall.Kevent_t{
+			Ident: ident, Flags: syscall.EV_DELETE, Filter: syscall.EVFILT_WRITE,
+		})
+	}
+	return nil
 }
 
 func (n *epoll) closeFD(fd int) error {
 	// 移除文件描述符的监听
+	n.lock.Lock()
+	defer n.lock.Unlock()
 	if len(n.changes) <= 1 {
 		n.changes = nil
 	} else {
@@ -120,34 +149,40 @@ func (n *epoll) closeFD(fd int) error {
 	return nil
 }
 
-func (n *epoll) getEvents() ([]event, error) {
-
-	epollEvents := make([]syscall.Kevent_t, 100)
-	changes := n.changes
+func (n *epoll) wait(handle func(e event)) error {
+	n.lock.Lock()
+	n.changeBuf = append(n.changeBuf[:0], n.changes...)
+	n.changeBuf = append(n.changeBuf, n.deletes...)
+	n.deletes = n.deletes[:0]
+	n.lock.Unlock()
 
 retry:
-	num, err := syscall.Kevent(n.epollFD, changes, epollEvents, &n.ts)
+	num, err := syscall.Kevent(n.epollFD, n.changeBuf, n.events, &n.ts)
 	if err != nil {
 		if err == syscall.EINTR {
 			goto retry
 		}
-		return nil, err
+		return err
 	}
 
-	events := make([]event, 0, len(epollEvents))
 	for i := 0; i < num; i++ {
-		event := event{
-			FD: int32(epollEvents[i].Ident),
+		// 删除不存在的写事件等操作失败时，会以EV_ERROR返回
+		if n.events[i].Flags&syscall.EV_ERROR != 0 {
+			continue
+		}
+		e := event{
+			FD: int32(n.events[i].Ident),
 		}
-		if epollEvents[i].Flags == EpollClose {
-			event.Type = EventClose
+		if n.events[i].Filter == syscall.EVFILT_WRITE {
+			e.Type = EventOut
+		} else if n.events[i].Flags == EpollClose {
+			e.Type = EventClose
 		} else {
-			event.Type = EventIn
+			e.Type = EventIn
 		}
-		events = append(events, event)
+		handle(e)
 	}
-
-	return events, nil
+	return nil
 }
 
 func (n *epoll) closeFDRead(fd int) error {
@@ -158,4 +193,17 @@ func (n *epoll) closeFDRead(fd int) error {
 	return nil
 }
 
+func (n *epoll) read(fd int, p []byte) (int, error) {
+	return syscall.Read(fd, p)
+}
+
+// direct 总是直接读取socket，没有已经接收的数据
+func (n *epoll) direct(fd int) bool {
+	return true
+}
+
+func (n *epoll) batchStart() {}
+
+func (n *epoll) batchEnd() {}
+
 var _ netpoll = &epoll{}
@@ -1,7 +1,6 @@
 package gn
 
 import (
-	"fmt"
 	"golang.org/x/sys/unix"
 	"syscall"
 )
@@ -13,74 +12,57 @@ const (
 )
 
 type epoll struct {
-	listenFD int
+	acceptor *acceptor
 	epollFD  int
+	events   []syscall.EpollEvent // 复用的事件数组，只在wait中使用
 }
 
-func newNetpoll(address string) (netpoll, error) {
-	listenFD, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
-	if err != nil {
-		log.Error(err)
-		return nil, err
-	}
-	err = syscall.SetsockoptInt(listenFD, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
-	if err != nil {
-		log.Error(err)
-		return nil, err
-	}
-
-	addr, port, err := getIPPort(address)
-	if err != nil {
-		return nil, err
-	}
-	err = syscall.Bind(listenFD, &syscall.SockaddrInet4{
-		Port: port,
-		Addr: addr,
-	})
-	if err != nil {
-		log.Error(err)
-		return nil, err
-	}
-	err = syscall.Listen(listenFD, 1024)
-	if err != nil {
-		log.Error(err)
-		return nil, err
+// newNetpoll 使用监听的文件描述符创建netpoll，创建失败时由调用方关闭监听的文件描述符
+func newNetpoll(listenFDs []int, options *options) (netpoll, error) {
+	if options.ioUring {
+		n, err := newUring(listenFDs, options)
+		if err == nil {
+			return n, nil
+		}
+		log.Info("io_uring not supported, fall back to epoll: ", err)
 	}
+	return newEpoll(listenFDs, options)
+}
 
+func newEpoll(listenFDs []int, options *options) (netpoll, error) {
 	epollFD, err := syscall.EpollCreate1(0)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	return &epoll{listenFD: listenFD, epollFD: epollFD}, nil
+	return &epoll{
+		acceptor: newAcceptor(listenFDs),
+		epollFD:  epollFD,
+		events:   make([]syscall.EpollEvent, options.pollBatchSize),
+	}, nil
 }
 
 func (n *epoll) accept() (nfd int, addr string, err error) {
-	nfd, sa, err := syscall.Accept(n.listenFD)
+	nfd, sa, err := n.acceptor.accept()
 	if err != nil {
 		return
 	}
 
-	// 设置为非阻塞状态
-	err = syscall.SetNonblock(nfd, true)
+	addr, err = sockaddrString(sa)
 	if err != nil {
-		return
+		_ = syscall.Close(nfd)
 	}
+	return
+}
 
-	err = syscall.EpollCtl(n.epollFD, syscall.EPOLL_CTL_ADD, nfd, &syscall.EpollEvent{
-		Events: EpollRead,
-		Fd:     int32(nfd),
-	})
-	if err != nil {
-		return
-	}
+// stopAccept 连接由accept goroutine主动接收，停止accept goroutine之后未接收的连接留在监听的队列中
+func (n *epoll) stopAccept() {}
 
-	s := sa.(*syscall.SockaddrInet4)
-	addr = fmt.Sprintf("%d.%d.%d.%d:%d", s.Addr[0], s.Addr[1], s.Addr[2], s.Addr[3], s.Port)
-	return
+func (n *epoll) listeners() []int {
+	return n.acceptor.fds
 }
 
-func (n *epoll) addRead(fd int) error {
+func (n *epoll) add(fd int) error {
 	err := syscall.EpollCtl(n.epollFD, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
 		Events: EpollRead,
 		Fd:     int32(fd),
@@ -91,43 +73,62 @@ func (n *epoll) addRead(fd int) error {
 	return nil
 }
 
-func (n *epoll) closeFD(fd int) error {
-	// 移除文件描述符的监听
-	err := syscall.EpollCtl(n.epollFD, syscall.EPOLL_CTL_DEL, fd, nil)
-	if err != nil {
-		return err
+func (n *epoll) modify(fd int, read, write bool) error {
+	events := uint32(EpollRead)
+	if !read {
+		// 保留EPOLLRDHUP，恢复读取之后可以检测到对端关闭
+		events &^= syscall.EPOLLIN | syscall.EPOLLPRI
 	}
+	if write {
+		events |= syscall.EPOLLOUT
+	}
+	// 边缘触发模式下，EPOLL_CTL_MOD会重新检查就绪状态，有数据可读或者可写时会产生新的事件
+	return syscall.EpollCtl(n.epollFD, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{
+		Events: events,
+		Fd:     int32(fd),
+	})
+}
+
+func (n *epoll) closeFD(fd int) error {
+	// 移除文件描述符的监听，监听失败的文件描述符同样需要关闭
+	delErr := syscall.EpollCtl(n.epollFD, syscall.EPOLL_CTL_DEL, fd, nil)
 
 	// 关闭文件描述符
-	err = syscall.Close(fd)
+	err := syscall.Close(fd)
 	if err != nil {
 		return err
 	}
-
+	if delErr != nil && delErr != syscall.ENOENT {
+		return delErr
+	}
 	return nil
 }
 
-func (n *epoll) getEvents() ([]event, error) {
-	epollEvents := make([]syscall.EpollEvent, 100)
-	num, err := syscall.EpollWait(n.epollFD, epollEvents, -1)
+func (n *epoll) wait(handle func(e event)) error {
+	num, err := syscall.EpollWait(n.epollFD, n.events, -1)
 	if err != nil {
-		return nil, err
+		if err == syscall.EINTR {
+			return nil
+		}
+		return err
 	}
 
-	events := make([]event, 0, len(epollEvents))
 	for i := 0; i < num; i++ {
-		event := event{
-			FD: epollEvents[i].Fd,
+		fd, flags := n.events[i].Fd, n.events[i].Events
+		if flags&syscall.EPOLLOUT != 0 {
+			handle(event{FD: fd, Type: EventOut})
+			flags &^= syscall.EPOLLOUT
+			if flags == 0 {
+				continue
+			}
 		}
-		if epollEvents[i].Events == EpollClose {
-			event.Type = EventClose
+		if flags == EpollClose {
+			handle(event{FD: fd, Type: EventClose})
 		} else {
-			event.Type = EventIn
+			handle(event{FD: fd, Type: EventIn})
 		}
-		events = append(events, event)
 	}
-
-	return events, nil
+	return nil
 }
 
 func (n *epoll) closeFDRead(fd int) error {
@@ -137,3 +138,16 @@ func (n *epoll) closeFDRead(fd int) error {
 	}
 	return nil
 }
+
+func (n *epoll) read(fd int, p []byte) (int, error) {
+	return syscall.Read(fd, p)
+}
+
+// direct 总是直接读取socket，没有已经接收的数据
+func (n *epoll) direct(fd int) bool {
+	return true
+}
+
+func (n *epoll) batchStart() {}
+
+func (n *epoll) batchEnd() {}
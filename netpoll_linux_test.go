@@ -0,0 +1,96 @@
+package gn
+
+import (
+	"syscall"
+	"testing"
+)
+
+// newBenchEpoll 创建一个始终有事件就绪的epoll，注册batch个水平触发的可读管道，返回的函数用于释放资源
+func newBenchEpoll(b *testing.B, batch int) (*epoll, func()) {
+	epollFD, err := syscall.EpollCreate1(0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	closeFDs := []int{epollFD}
+	for i := 0; i < batch; i++ {
+		var fds [2]int
+		err = syscall.Pipe(fds[:])
+		if err != nil {
+			b.Fatal(err)
+		}
+		closeFDs = append(closeFDs, fds[0], fds[1])
+		syscall.Write(fds[1], []byte{1})
+		err = syscall.EpollCtl(epollFD, syscall.EPOLL_CTL_ADD, fds[0], &syscall.EpollEvent{
+			Events: syscall.EPOLLIN,
+			Fd:     int32(fds[0]),
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	release := func() {
+		for _, fd := range closeFDs {
+			syscall.Close(fd)
+		}
+	}
+	return &epoll{epollFD: epollFD, events: make([]syscall.EpollEvent, batch)}, release
+}
+
+// legacyGetEvents 原来的实现，每次等待都分配事件数组以及event切片，用于对比
+func (n *epoll) legacyGetEvents() ([]event, error) {
+	epollEvents := make([]syscall.EpollEvent, 100)
+	num, err := syscall.EpollWait(n.epollFD, epollEvents, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]event, 0, len(epollEvents))
+	for i := 0; i < num; i++ {
+		event := event{
+			FD: epollEvents[i].Fd,
+		}
+		if epollEvents[i].Events == EpollClose {
+			event.Type = EventClose
+		} else {
+			event.Type = EventIn
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+var benchEvents int
+
+func BenchmarkEpollWait(b *testing.B) {
+	n, release := newBenchEpoll(b, 100)
+	defer release()
+	handle := func(e event) {
+		benchEvents++
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := n.wait(handle)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEpollGetEventsLegacy(b *testing.B) {
+	n, release := newBenchEpoll(b, 100)
+	defer release()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		events, err := n.legacyGetEvents()
+		if err != nil {
+			b.Fatal(err)
+		}
+		for range events {
+			benchEvents++
+		}
+	}
+}
@@ -3,6 +3,6 @@
 
 package gn
 
-func newNetpoll(address string) (netpoll, error) {
+func newNetpoll(listenFDs []int, options *options) (netpoll, error) {
 	panic("please run on linux or mac")
 }
@@ -0,0 +1,1027 @@
+package gn
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// io_uring系统调用以及内核结构体中用到的常量，参考linux/io_uring.h
+const (
+	sysIOUringSetup    = 425
+	sysIOUringEnter    = 426
+	sysIOUringRegister = 427
+
+	iouringOffSQRing = 0
+	iouringOffSQEs   = 0x10000000
+
+	iouringFeatSingleMmap = 1 << 0
+	iouringFeatNoDrop     = 1 << 1
+
+	iouringOpNop         = 0
+	iouringOpPollAdd     = 6
+	iouringOpPollRemove  = 7
+	iouringOpSendmsg     = 9
+	iouringOpAccept      = 13
+	iouringOpAsyncCancel = 14
+	iouringOpRecv        = 27
+
+	iouringRegisterPbufRing = 22
+
+	iouringSQEBufferSelect  = 1 << 5
+	iouringPollAddMulti     = 1 << 0
+	iouringAcceptMultishot  = 1 << 0
+	iouringEnterGetEvents   = 1 << 0
+	iouringCQEFlagBuffer    = 1 << 0
+	iouringCQEFlagMore      = 1 << 1
+	iouringCQEBufferShift   = 16
+	iouringEntries          = 1024
+	iouringBufferEntries    = 1024 // provided buffer ring中缓存区的数量，每个缓存区的大小是读缓存区的大小
+	iouringBufferGroup      = 0
+	iouringMinKernelVersion = 5<<16 | 19<<8 // multishot accept以及provided buffer ring需要5.19
+)
+
+// user_data的高8位表示请求类型，中间24位是poll请求的代数（接收、发送请求使用文件描述符的编号），低32位是文件描述符
+const (
+	uringKindAccept = 1
+	uringKindPoll   = 2
+	uringKindRemove = 3
+	uringKindRecv   = 4
+	uringKindSend   = 5
+	uringKindWake   = 6
+)
+
+const (
+	uringPollRead  = unix.POLLIN | unix.POLLPRI | unix.POLLERR | unix.POLLHUP | unix.POLLRDHUP
+	uringPollClose = unix.POLLIN | unix.POLLRDHUP
+)
+
+var errUringKernel = errors.New("io_uring requires linux 5.19 or later")
+
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        uringSQOffsets
+	cqOff        uringCQOffsets
+}
+
+type uringSQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type uringCQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFDIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringBuf provided buffer ring中的缓存区，第一个缓存区的resv是ring的tail
+type uringBuf struct {
+	addr uint64
+	len  uint32
+	bid  uint16
+	resv uint16
+}
+
+type uringBufReg struct {
+	ringAddr    uint64
+	ringEntries uint32
+	bgid        uint16
+	flags       uint16
+	resv        [3]uint64
+}
+
+// uringFD 注册到io_uring的文件描述符
+type uringFD struct {
+	id       uint32       // 编号，文件描述符复用之后，丢弃旧连接的接收、发送请求产生的完成事件
+	gen      uint32       // poll请求的代数，重新提交poll之后，丢弃旧的poll请求产生的完成事件
+	mask     uint32       // poll监听的事件，为0时没有poll请求
+	read     bool         // 是否监听读事件
+	write    bool         // 是否监听写事件
+	direct   bool         // 是否直接读取socket，不再通过io_uring接收数据，用于Splice以及连接移交
+	fallback bool         // provided buffer ring中没有空闲的缓存区，直接读取socket直到EAGAIN
+	recving  bool         // 是否有尚未完成的接收请求
+	chunks   []uringChunk // 已经接收尚未读取的数据
+	err      error        // 接收产生的错误，读完chunks之后返回
+	sending  bool         // 是否有尚未完成的发送请求
+	sent     int          // 发送完成尚未被取走的字节数
+	sendErr  error        // 发送产生的错误
+}
+
+// uringChunk 接收到provided buffer ring中的数据，读完之后归还缓存区
+type uringChunk struct {
+	bid  uint16
+	data []byte
+}
+
+// uringSend 尚未完成的发送请求，完成之前内核引用其中的内存
+type uringSend struct {
+	msg     unix.Msghdr
+	iovecs  []unix.Iovec
+	buffers [][]byte
+}
+
+// acceptResult multishot accept产生的连接
+type acceptResult struct {
+	fd  int
+	err error
+}
+
+// uring 基于io_uring的netpoll实现，使用multishot accept接收连接，使用recv从provided buffer ring中选择缓存区接收数据，
+// 使用sendmsg异步发送写缓存区中的数据，IO goroutine处理一批事件期间产生的请求在处理完成之后一次提交；
+// 写事件以及暂停读取期间的对端关闭仍然使用multishot poll监听，Splice以及连接移交时切换为直接读取socket
+type uring struct {
+	listenFDs []int
+	ringFD    int
+	ring      []byte // SQ、CQ共享的内存
+	sqes      []byte // SQE数组的内存
+
+	sqHead    *uint32
+	sqTail    *uint32
+	sqMask    uint32
+	sqEntries uint32
+	sqArray   []uint32
+	sqeArray  []uringSQE
+	cqHead    *uint32
+	cqTail    *uint32
+	cqMask    uint32
+	cqeArray  []uringCQE
+
+	bufRing []byte // provided buffer ring的内存
+	bufs    []byte // provided buffer ring中缓存区的内存
+	bufLen  int    // 每个缓存区的大小
+	bufTail uint16 // provided buffer ring的tail
+
+	lock     sync.Mutex            // 保护SQ、provided buffer ring、fds以及sends
+	fds      map[int]*uringFD      // 注册的文件描述符
+	sends    map[uint64]*uringSend // 尚未完成的发送请求，key是user_data
+	gen      uint32                // 文件描述符编号以及poll请求的代数
+	busy     int32                 // 正在处理一批事件的IO goroutine数量，大于0时延迟提交请求
+	done     *sync.Cond            // 接收或者发送请求完成，切换为直接读取时等待取消的请求
+	accepted chan acceptResult     // 接收到的连接
+
+	acceptLock    sync.Mutex // 保护acceptStopped以及向accepted写入
+	acceptStopped bool       // 是否已经停止接收连接
+}
+
+func newUring(listenFDs []int, options *options) (netpoll, error) {
+	if kernelVersion() < iouringMinKernelVersion {
+		return nil, errUringKernel
+	}
+
+	params := uringParams{}
+	fd, _, errno := syscall.Syscall(sysIOUringSetup, iouringEntries, uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	n := &uring{
+		ringFD:   int(fd),
+		fds:      make(map[int]*uringFD),
+		sends:    make(map[uint64]*uringSend),
+		accepted: make(chan acceptResult, iouringEntries),
+	}
+	n.done = sync.NewCond(&n.lock)
+	err := n.mmap(&params)
+	if err != nil {
+		_ = syscall.Close(n.ringFD)
+		return nil, err
+	}
+	err = n.setupBuffers(options.readBufferLen)
+	if err != nil {
+		n.unmap()
+		return nil, err
+	}
+
+	n.listenFDs = listenFDs
+	for _, listenFD := range n.listenFDs {
+		err = n.submitAccept(listenFD)
+		if err != nil {
+			n.unmap()
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// kernelVersion 返回内核版本，格式为major<<16 | minor<<8
+func kernelVersion() int {
+	var uts syscall.Utsname
+	if syscall.Uname(&uts) != nil {
+		return 0
+	}
+	var major, minor int
+	var release []byte
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	_, _ = fmt.Sscanf(string(release), "%d.%d", &major, &minor)
+	return major<<16 | minor<<8
+}
+
+// mmap 映射SQ、CQ以及SQE数组
+func (n *uring) mmap(p *uringParams) error {
+	if p.features&iouringFeatSingleMmap == 0 || p.features&iouringFeatNoDrop == 0 {
+		return errUringKernel
+	}
+
+	sqLen := p.sqOff.array + p.sqEntries*4
+	cqLen := p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{}))
+	ringLen := sqLen
+	if cqLen > ringLen {
+		ringLen = cqLen
+	}
+	ring, err := syscall.Mmap(n.ringFD, iouringOffSQRing, int(ringLen),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return err
+	}
+	sqes, err := syscall.Mmap(n.ringFD, iouringOffSQEs, int(p.sqEntries)*int(unsafe.Sizeof(uringSQE{})),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		_ = syscall.Munmap(ring)
+		return err
+	}
+	n.ring, n.sqes = ring, sqes
+
+	base := unsafe.Pointer(&ring[0])
+	n.sqHead = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.sqOff.head)))
+	n.sqTail = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.sqOff.tail)))
+	n.sqMask = *(*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.sqOff.ringMask)))
+	n.sqEntries = p.sqEntries
+	n.sqArray = (*[1 << 20]uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.sqOff.array)))[:p.sqEntries:p.sqEntries]
+	n.sqeArray = (*[1 << 20]uringSQE)(unsafe.Pointer(&sqes[0]))[:p.sqEntries:p.sqEntries]
+	n.cqHead = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.cqOff.head)))
+	n.cqTail = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.cqOff.tail)))
+	n.cqMask = *(*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.cqOff.ringMask)))
+	n.cqeArray = (*[1 << 20]uringCQE)(unsafe.Pointer(uintptr(base) + uintptr(p.cqOff.cqes)))[:p.cqEntries:p.cqEntries]
+	return nil
+}
+
+func (n *uring) unmap() {
+	_ = syscall.Munmap(n.sqes)
+	_ = syscall.Munmap(n.ring)
+	_ = syscall.Close(n.ringFD)
+	if n.bufRing != nil {
+		_ = syscall.Munmap(n.bufs)
+		_ = syscall.Munmap(n.bufRing)
+	}
+}
+
+// setupBuffers 注册provided buffer ring，接收数据时由内核选择空闲的缓存区，不需要为每个等待数据的连接预先分配
+func (n *uring) setupBuffers(bufLen int) error {
+	ring, err := syscall.Mmap(-1, 0, iouringBufferEntries*int(unsafe.Sizeof(uringBuf{})),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return err
+	}
+	bufs, err := syscall.Mmap(-1, 0, iouringBufferEntries*bufLen,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		_ = syscall.Munmap(ring)
+		return err
+	}
+
+	reg := uringBufReg{
+		ringAddr:    uint64(uintptr(unsafe.Pointer(&ring[0]))),
+		ringEntries: iouringBufferEntries,
+		bgid:        iouringBufferGroup,
+	}
+	_, _, errno := syscall.Syscall6(sysIOUringRegister, uintptr(n.ringFD), iouringRegisterPbufRing,
+		uintptr(unsafe.Pointer(&reg)), 1, 0, 0)
+	if errno != 0 {
+		_ = syscall.Munmap(bufs)
+		_ = syscall.Munmap(ring)
+		return errno
+	}
+
+	n.bufRing, n.bufs, n.bufLen = ring, bufs, bufLen
+	for i := 0; i < iouringBufferEntries; i++ {
+		n.recycle(uint16(i))
+	}
+	return nil
+}
+
+// recycle 将缓存区归还给provided buffer ring，调用方持有lock
+func (n *uring) recycle(bid uint16) {
+	size := int(unsafe.Sizeof(uringBuf{}))
+	buf := (*uringBuf)(unsafe.Pointer(&n.bufRing[int(n.bufTail&(iouringBufferEntries-1))*size]))
+	buf.addr = uint64(uintptr(unsafe.Pointer(&n.bufs[int(bid)*n.bufLen])))
+	buf.len = uint32(n.bufLen)
+	buf.bid = bid
+	n.bufTail++
+
+	// tail和第一个缓存区的bid在同一个32位字中，一起原子写入，内核读取tail时看到完整的缓存区
+	first := (*uringBuf)(unsafe.Pointer(&n.bufRing[0]))
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&first.bid)), uint32(n.bufTail)<<16|uint32(first.bid))
+}
+
+// submit 写入一个请求，调用方持有lock，IO goroutine正在处理一批事件时，延迟到batchEnd一起提交
+func (n *uring) submit(sqe uringSQE) error {
+	tail := *n.sqTail
+	// SQ已满，先提交给内核
+	for tail-atomic.LoadUint32(n.sqHead) >= n.sqEntries {
+		err := n.flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	index := tail & n.sqMask
+	n.sqeArray[index] = sqe
+	n.sqArray[index] = index
+	atomic.StoreUint32(n.sqTail, tail+1)
+	if atomic.LoadInt32(&n.busy) > 0 {
+		return nil
+	}
+	return n.flush()
+}
+
+// submitNow 写入一个请求并立即提交，用于需要等待完成的请求，调用方持有lock
+func (n *uring) submitNow(sqe uringSQE) error {
+	err := n.submit(sqe)
+	if err != nil {
+		return err
+	}
+	return n.flush()
+}
+
+// flush 提交SQ中所有尚未提交的请求，调用方持有lock
+func (n *uring) flush() error {
+	pending := *n.sqTail - atomic.LoadUint32(n.sqHead)
+	if pending == 0 {
+		return nil
+	}
+	return n.enter(pending, 0, 0)
+}
+
+func (n *uring) batchStart() {
+	atomic.AddInt32(&n.busy, 1)
+}
+
+func (n *uring) batchEnd() {
+	atomic.AddInt32(&n.busy, -1)
+	n.lock.Lock()
+	err := n.flush()
+	n.lock.Unlock()
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// enter 调用io_uring_enter，提交toSubmit个请求，minComplete大于0时等待完成事件
+func (n *uring) enter(toSubmit, minComplete uint32, flags uintptr) error {
+	for {
+		_, _, errno := syscall.Syscall6(sysIOUringEnter, uintptr(n.ringFD), uintptr(toSubmit),
+			uintptr(minComplete), flags, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+}
+
+func userData(kind int, gen uint32, fd int) uint64 {
+	return uint64(kind)<<56 | uint64(gen&0xffffff)<<32 | uint64(uint32(fd))
+}
+
+// submitAccept 为监听的文件描述符提交multishot accept请求
+func (n *uring) submitAccept(listenFD int) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.submit(uringSQE{
+		opcode:   iouringOpAccept,
+		ioprio:   iouringAcceptMultishot,
+		fd:       int32(listenFD),
+		opFlags:  syscall.SOCK_NONBLOCK | syscall.SOCK_CLOEXEC,
+		userData: userData(uringKindAccept, 0, listenFD),
+	})
+}
+
+// nextGen 获取新的文件描述符编号或者poll请求的代数，调用方持有lock
+func (n *uring) nextGen() uint32 {
+	n.gen = (n.gen + 1) & 0xffffff
+	return n.gen
+}
+
+// submitPoll 提交multishot poll请求，调用方持有lock
+func (n *uring) submitPoll(fd int, f *uringFD) error {
+	return n.submit(uringSQE{
+		opcode:   iouringOpPollAdd,
+		fd:       int32(fd),
+		len:      iouringPollAddMulti,
+		opFlags:  f.mask,
+		userData: userData(uringKindPoll, f.gen, fd),
+	})
+}
+
+// removePoll 取消poll请求，调用方持有lock
+func (n *uring) removePoll(fd int, f *uringFD) error {
+	return n.submit(uringSQE{
+		opcode:   iouringOpPollRemove,
+		fd:       -1,
+		addr:     userData(uringKindPoll, f.gen, fd),
+		userData: userData(uringKindRemove, f.gen, fd),
+	})
+}
+
+// updatePoll 根据读写事件计算poll监听的事件，变化时取消旧的poll请求，使用新的代数重新提交，
+// 重新提交时如果已经就绪，会立即产生完成事件，调用方持有lock
+func (n *uring) updatePoll(fd int, f *uringFD) error {
+	var mask uint32 = uringPollRead
+	if !f.read {
+		// 保留POLLRDHUP，恢复读取之后可以检测到对端关闭
+		mask &^= unix.POLLIN | unix.POLLPRI
+	} else if !f.direct {
+		// 通过接收请求读取数据，接收完成事件代替读事件
+		mask = 0
+	}
+	if f.write {
+		mask |= unix.POLLOUT
+	}
+	if mask == f.mask {
+		return nil
+	}
+
+	if f.mask != 0 {
+		err := n.removePoll(fd, f)
+		if err != nil {
+			return err
+		}
+	}
+	f.gen = n.nextGen()
+	f.mask = mask
+	if mask == 0 {
+		return nil
+	}
+	return n.submitPoll(fd, f)
+}
+
+// cancel 取消user_data为target的请求，调用方持有lock
+func (n *uring) cancel(target uint64, fd int) error {
+	return n.submit(uringSQE{
+		opcode:   iouringOpAsyncCancel,
+		fd:       -1,
+		addr:     target,
+		userData: userData(uringKindRemove, 0, fd),
+	})
+}
+
+// armRecv 提交接收请求，由内核从provided buffer ring中选择缓存区，每个文件描述符最多一个接收请求，调用方持有lock
+func (n *uring) armRecv(fd int, f *uringFD) error {
+	if f.recving || f.direct || f.fallback {
+		return nil
+	}
+	err := n.submit(uringSQE{
+		opcode:   iouringOpRecv,
+		flags:    iouringSQEBufferSelect,
+		fd:       int32(fd),
+		len:      uint32(n.bufLen),
+		bufIndex: iouringBufferGroup,
+		userData: userData(uringKindRecv, f.id, fd),
+	})
+	if err != nil {
+		return err
+	}
+	f.recving = true
+	return nil
+}
+
+func (n *uring) accept() (nfd int, addr string, err error) {
+	var result acceptResult
+	select {
+	case result = <-n.accepted:
+	case <-time.After(acceptTimeout):
+		return 0, "", syscall.EAGAIN
+	}
+	if result.err != nil {
+		return 0, "", result.err
+	}
+	nfd = result.fd
+
+	sa, err := syscall.Getpeername(nfd)
+	if err != nil {
+		_ = syscall.Close(nfd)
+		return 0, "", err
+	}
+	addr, err = sockaddrString(sa)
+	if err != nil {
+		_ = syscall.Close(nfd)
+		return 0, "", err
+	}
+	return nfd, addr, nil
+}
+
+// pushAccepted 将multishot accept的结果交给accept goroutine，不能阻塞CQE的处理，
+// 停止接收连接或者accepted已满时关闭连接，返回是否已经停止接收连接
+func (n *uring) pushAccepted(res int32) bool {
+	n.acceptLock.Lock()
+	defer n.acceptLock.Unlock()
+
+	result := acceptResult{fd: int(res)}
+	if res < 0 {
+		result = acceptResult{err: syscall.Errno(-res)}
+	}
+	if n.acceptStopped {
+		if res >= 0 {
+			_ = syscall.Close(int(res))
+		}
+		return true
+	}
+
+	select {
+	case n.accepted <- result:
+	default:
+		if res >= 0 {
+			_ = syscall.Close(int(res))
+		}
+		log.Error("io_uring accepted queue is full, drop conn")
+	}
+	return false
+}
+
+// stopAccept 取消multishot accept，关闭已经接收但是accept goroutine尚未取走的连接
+func (n *uring) stopAccept() {
+	n.acceptLock.Lock()
+	defer n.acceptLock.Unlock()
+	if n.acceptStopped {
+		return
+	}
+	n.acceptStopped = true
+
+	n.lock.Lock()
+	for _, listenFD := range n.listenFDs {
+		err := n.cancel(userData(uringKindAccept, 0, listenFD), listenFD)
+		if err == nil {
+			err = n.flush()
+		}
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	n.lock.Unlock()
+
+	for {
+		select {
+		case result := <-n.accepted:
+			if result.err == nil {
+				_ = syscall.Close(result.fd)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (n *uring) listeners() []int {
+	return n.listenFDs
+}
+
+// fdOf 获取文件描述符的状态，不存在时创建，注册之前（例如在OnConnect中）发送数据时也需要记录发送状态，调用方持有lock
+func (n *uring) fdOf(fd int) *uringFD {
+	f, ok := n.fds[fd]
+	if !ok {
+		f = &uringFD{id: n.nextGen()}
+		n.fds[fd] = f
+	}
+	return f
+}
+
+func (n *uring) add(fd int) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	f := n.fdOf(fd)
+	f.read = true
+	err := n.updatePoll(fd, f)
+	if err == nil {
+		err = n.armRecv(fd, f)
+	}
+	if err != nil {
+		delete(n.fds, fd)
+		return err
+	}
+	return nil
+}
+
+func (n *uring) modify(fd int, read, write bool) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	f, ok := n.fds[fd]
+	if !ok {
+		return syscall.ENOENT
+	}
+
+	resumed := read && !f.read
+	f.read, f.write = read, write
+	err := n.updatePoll(fd, f)
+	if err != nil || !read || f.direct {
+		return err
+	}
+	// 恢复读取时，已经接收的数据尚未读取，通过NOP请求的完成事件产生读事件
+	if len(f.chunks) > 0 || f.err != nil || f.fallback {
+		if !resumed {
+			return nil
+		}
+		return n.submit(uringSQE{
+			opcode:   iouringOpNop,
+			fd:       -1,
+			userData: userData(uringKindWake, f.id, fd),
+		})
+	}
+	return n.armRecv(fd, f)
+}
+
+// read 先读取已经接收的数据，读完之后返回接收产生的错误；没有数据时重新提交接收请求，返回EAGAIN，
+// 直接读取模式或者provided buffer ring用完之后，使用read(2)读取socket
+func (n *uring) read(fd int, p []byte) (int, error) {
+	n.lock.Lock()
+	f, ok := n.fds[fd]
+	if !ok {
+		n.lock.Unlock()
+		return syscall.Read(fd, p)
+	}
+
+	var copied int
+	for len(f.chunks) > 0 && copied < len(p) {
+		chunk := &f.chunks[0]
+		m := copy(p[copied:], chunk.data)
+		copied += m
+		chunk.data = chunk.data[m:]
+		if len(chunk.data) == 0 {
+			n.recycle(chunk.bid)
+			f.chunks[0] = uringChunk{}
+			f.chunks = f.chunks[1:]
+		}
+	}
+	if len(f.chunks) == 0 {
+		f.chunks = nil
+	}
+	if copied > 0 || len(p) == 0 {
+		n.lock.Unlock()
+		return copied, nil
+	}
+	if f.err != nil {
+		n.lock.Unlock()
+		return 0, f.err
+	}
+	if !f.direct && !f.fallback {
+		var err error
+		if f.read {
+			err = n.armRecv(fd, f)
+		}
+		n.lock.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		return 0, syscall.EAGAIN
+	}
+	direct := f.direct
+	n.lock.Unlock()
+
+	m, err := syscall.Read(fd, p)
+	if direct {
+		return m, err
+	}
+	if err == nil && m == 0 {
+		return 0, io.EOF
+	}
+	if err != syscall.EAGAIN {
+		return m, err
+	}
+
+	// socket中的数据已经读完，重新通过接收请求读取
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.fds[fd] == f && f.fallback {
+		f.fallback = false
+		if f.read {
+			err := n.armRecv(fd, f)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return 0, syscall.EAGAIN
+}
+
+// direct 切换为直接读取socket，取消尚未完成的接收以及发送请求并等待完成事件，
+// 返回是否没有已经接收尚未读取的数据，有数据时需要先通过read读完
+func (n *uring) direct(fd int) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	f, ok := n.fds[fd]
+	if !ok {
+		return true
+	}
+
+	var err error
+	if f.recving {
+		err = n.cancel(userData(uringKindRecv, f.id, fd), fd)
+	}
+	if err == nil && f.sending {
+		err = n.cancel(userData(uringKindSend, f.id, fd), fd)
+	}
+	// 需要立即提交，完成事件由wait处理，不能等到当前IO goroutine的batchEnd
+	if err == nil {
+		err = n.flush()
+	}
+	if err != nil {
+		log.Error(err)
+	}
+	for err == nil && (f.recving || f.sending) {
+		n.done.Wait()
+	}
+
+	if !f.direct {
+		f.direct = true
+		f.fallback = false
+		err := n.updatePoll(fd, f)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	return len(f.chunks) == 0 && f.err == nil
+}
+
+func (n *uring) send(fd int, buffers [][]byte) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	f := n.fdOf(fd)
+	if f.sending {
+		return syscall.EBUSY
+	}
+
+	s := &uringSend{
+		iovecs:  make([]unix.Iovec, 0, len(buffers)),
+		buffers: append([][]byte(nil), buffers...),
+	}
+	for _, b := range s.buffers {
+		if len(b) == 0 {
+			continue
+		}
+		iovec := unix.Iovec{Base: &b[0]}
+		iovec.SetLen(len(b))
+		s.iovecs = append(s.iovecs, iovec)
+	}
+	if len(s.iovecs) > 0 {
+		s.msg.Iov = &s.iovecs[0]
+		s.msg.SetIovlen(len(s.iovecs))
+	}
+
+	key := userData(uringKindSend, f.id, fd)
+	err := n.submit(uringSQE{
+		opcode:   iouringOpSendmsg,
+		fd:       int32(fd),
+		addr:     uint64(uintptr(unsafe.Pointer(&s.msg))),
+		len:      1,
+		opFlags:  syscall.MSG_NOSIGNAL,
+		userData: key,
+	})
+	if err != nil {
+		return err
+	}
+	n.sends[key] = s
+	f.sending = true
+	return nil
+}
+
+func (n *uring) sent(fd int) (int, bool, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	f, ok := n.fds[fd]
+	if !ok {
+		return 0, false, nil
+	}
+	if f.sending {
+		return 0, true, nil
+	}
+	sent, err := f.sent, f.sendErr
+	f.sent, f.sendErr = 0, nil
+	return sent, false, err
+}
+
+func (n *uring) closeFD(fd int) error {
+	n.lock.Lock()
+	f, ok := n.fds[fd]
+	if ok {
+		delete(n.fds, fd)
+		// poll、接收以及发送请求持有文件的引用，需要先取消
+		var err error
+		if f.mask != 0 {
+			err = n.removePoll(fd, f)
+		}
+		if err == nil && f.recving {
+			err = n.cancel(userData(uringKindRecv, f.id, fd), fd)
+		}
+		if err == nil && f.sending {
+			err = n.cancel(userData(uringKindSend, f.id, fd), fd)
+		}
+		if err != nil {
+			log.Error(err)
+		}
+		for _, chunk := range f.chunks {
+			n.recycle(chunk.bid)
+		}
+	}
+	n.lock.Unlock()
+
+	return syscall.Close(fd)
+}
+
+func (n *uring) closeFDRead(fd int) error {
+	return syscall.Shutdown(fd, syscall.SHUT_RD)
+}
+
+func (n *uring) wait(handle func(e event)) error {
+	// 一起提交在完成事件中重新提交的请求
+	n.lock.Lock()
+	err := n.flush()
+	n.lock.Unlock()
+	if err == nil {
+		err = n.enter(0, 1, iouringEnterGetEvents)
+	}
+	if err != nil {
+		if err == syscall.EINTR {
+			return nil
+		}
+		return err
+	}
+
+	head := atomic.LoadUint32(n.cqHead)
+	tail := atomic.LoadUint32(n.cqTail)
+	for ; head != tail; head++ {
+		n.handleCQE(&n.cqeArray[head&n.cqMask], handle)
+	}
+	atomic.StoreUint32(n.cqHead, head)
+	return nil
+}
+
+// handleCQE 将完成事件转换为event
+func (n *uring) handleCQE(cqe *uringCQE, handle func(e event)) {
+	kind := int(cqe.userData >> 56)
+	gen := uint32(cqe.userData>>32) & 0xffffff
+	fd := int(int32(uint32(cqe.userData)))
+	more := cqe.flags&iouringCQEFlagMore != 0
+
+	switch kind {
+	case uringKindAccept:
+		stopped := n.pushAccepted(cqe.res)
+		// multishot accept结束之后重新提交，停止接收连接之后不再提交
+		if !more && !stopped {
+			err := n.submitAccept(fd)
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	case uringKindPoll:
+		n.lock.Lock()
+		f, ok := n.fds[fd]
+		current := ok && f.gen == gen
+		if current && !more && cqe.res != -int32(syscall.ECANCELED) {
+			// multishot poll被内核结束，重新提交
+			err := n.submitPoll(fd, f)
+			if err != nil {
+				log.Error(err)
+			}
+		}
+		n.lock.Unlock()
+		if !current || cqe.res < 0 {
+			return
+		}
+
+		revents := uint32(cqe.res)
+		if revents&unix.POLLOUT != 0 {
+			handle(event{FD: int32(fd), Type: EventOut})
+			revents &^= unix.POLLOUT
+			if revents == 0 {
+				return
+			}
+		}
+		if revents == uringPollClose {
+			handle(event{FD: int32(fd), Type: EventClose})
+		} else {
+			handle(event{FD: int32(fd), Type: EventIn})
+		}
+	case uringKindRecv:
+		if n.handleRecv(cqe, gen, fd) {
+			handle(event{FD: int32(fd), Type: EventIn})
+		}
+	case uringKindSend:
+		n.lock.Lock()
+		delete(n.sends, cqe.userData)
+		f, ok := n.fds[fd]
+		current := ok && f.id == gen
+		if current {
+			f.sending = false
+			n.done.Broadcast()
+			// 切换为直接读取时取消的发送请求，之后的flush重新发送
+			if cqe.res >= 0 {
+				f.sent += int(cqe.res)
+			} else if cqe.res != -int32(syscall.ECANCELED) {
+				f.sendErr = syscall.Errno(-cqe.res)
+			}
+		}
+		n.lock.Unlock()
+		if current {
+			handle(event{FD: int32(fd), Type: EventOut})
+		}
+	case uringKindWake:
+		n.lock.Lock()
+		f, ok := n.fds[fd]
+		current := ok && f.id == gen && f.read
+		n.lock.Unlock()
+		if current {
+			handle(event{FD: int32(fd), Type: EventIn})
+		}
+	}
+}
+
+// handleRecv 处理接收请求的完成事件，数据放入chunks，返回是否需要产生读事件
+func (n *uring) handleRecv(cqe *uringCQE, gen uint32, fd int) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	f, ok := n.fds[fd]
+	if !ok || f.id != gen {
+		// 文件描述符已经关闭，归还缓存区
+		if cqe.flags&iouringCQEFlagBuffer != 0 {
+			n.recycle(uint16(cqe.flags >> iouringCQEBufferShift))
+		}
+		return false
+	}
+	f.recving = false
+	n.done.Broadcast()
+
+	switch {
+	case cqe.res > 0:
+		bid := uint16(cqe.flags >> iouringCQEBufferShift)
+		offset := int(bid) * n.bufLen
+		f.chunks = append(f.chunks, uringChunk{bid: bid, data: n.bufs[offset : offset+int(cqe.res)]})
+	case cqe.res == 0:
+		f.err = io.EOF
+	case cqe.res == -int32(syscall.ECANCELED):
+		return false
+	case cqe.res == -int32(syscall.ENOBUFS):
+		// provided buffer ring中没有空闲的缓存区，直接读取socket
+		f.fallback = true
+	default:
+		f.err = syscall.Errno(-cqe.res)
+	}
+	return f.read
+}
+
+var _ netpoll = &uring{}
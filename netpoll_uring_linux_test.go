@@ -0,0 +1,156 @@
+package gn
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIOUring(t *testing.T) {
+	server, address := startTestServer(t, &echoHandler{}, WithIOUring())
+	if _, ok := server.netpoll.(*uring); !ok {
+		t.Skip("io_uring not supported")
+	}
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 超过socket缓冲区，覆盖写缓存区以及可写事件
+		content := make([]byte, 4<<20)
+		for i := range content {
+			content[i] = byte(i)
+		}
+		go conn.Write(content)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		bytes := make([]byte, len(content))
+		_, err = io.ReadFull(conn, bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range bytes {
+			if bytes[i] != content[i] {
+				t.Fatal(i)
+			}
+		}
+		conn.Close()
+	}
+}
+
+func TestIOUringShutdown(t *testing.T) {
+	server, address := startTestServer(t, &echoHandler{}, WithIOUring())
+	n, ok := server.netpoll.(*uring)
+	if !ok {
+		t.Skip("io_uring not supported")
+	}
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func() {
+		conn.Write([]byte("a"))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := io.ReadFull(conn, make([]byte, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	request()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Shutdown(5 * time.Second)
+	}()
+	for stopped := false; !stopped; time.Sleep(10 * time.Millisecond) {
+		n.acceptLock.Lock()
+		stopped = n.acceptStopped
+		n.acceptLock.Unlock()
+	}
+
+	// 停止接收连接之后，新连接留在监听的队列中，不会被multishot accept接收之后关闭
+	for i := 0; i < 16; i++ {
+		c, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		_, err = c.Read(make([]byte, 1))
+		if err, ok := err.(net.Error); !ok || !err.Timeout() {
+			t.Fatal(err)
+		}
+	}
+	if len(n.accepted) != 0 {
+		t.Fatal(len(n.accepted))
+	}
+
+	// 已经建立的连接仍然可以处理，关闭之后Shutdown返回
+	request()
+	conn.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIOUringSplice(t *testing.T) {
+	// 切换为直接读取socket之前，先转发已经接收到provided buffer ring中的数据
+	testSplice(t, WithIOUring())
+}
+
+func TestIOUringRateLimitPause(t *testing.T) {
+	// 暂停读取期间接收完成的数据，恢复读取之后产生读事件
+	testRateLimitPause(t, WithIOUring())
+}
+
+func TestIOUringBatch(t *testing.T) {
+	server, address := startTestServer(t, &echoHandler{}, WithIOUring())
+	n, ok := server.netpoll.(*uring)
+	if !ok {
+		t.Skip("io_uring not supported")
+	}
+
+	// 处理一批事件期间写入的请求不会立即提交，batchEnd之后一起提交
+	n.batchStart()
+	n.lock.Lock()
+	err := n.submit(uringSQE{opcode: iouringOpNop, fd: -1, userData: userData(uringKindRemove, 0, 0)})
+	pending := *n.sqTail - atomic.LoadUint32(n.sqHead)
+	n.lock.Unlock()
+	if err != nil || pending != 1 {
+		t.Fatal(err, pending)
+	}
+	n.batchEnd()
+	n.lock.Lock()
+	pending = *n.sqTail - atomic.LoadUint32(n.sqHead)
+	n.lock.Unlock()
+	if pending != 0 {
+		t.Fatal(pending)
+	}
+
+	// 每次接收使用provided buffer ring中的一个缓存区，读完之后归还，数据量超过所有缓存区的大小
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	content := make([]byte, 2*iouringBufferEntries*1024)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	go conn.Write(content)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	bytes := make([]byte, len(content))
+	_, err = io.ReadFull(conn, bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range bytes {
+		if bytes[i] != content[i] {
+			t.Fatal(i)
+		}
+	}
+}
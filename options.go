@@ -8,15 +8,41 @@ import (
 
 // options Server初始化参数
 type options struct {
-	decoder         codec.Decoder // 解码器
-	encoder         codec.Encoder // 编码器
-	readBufferLen   int           // 所读取的客户端包的最大长度，客户端发送的包不能超过这个长度，默认值是1024字节
-	acceptGNum      int           // 处理接受请求的goroutine数量
-	ioGNum          int           // 处理io的goroutine数量
-	ioEventQueueLen int           // io事件队列长度
-	timeout         time.Duration // 超时时间
+	decoder                  codec.Decoder   // 解码器
+	encoder                  codec.Encoder   // 编码器
+	codecFactory             CodecFactory    // 编解码器工厂
+	messageCodec             MessageCodec    // 消息编解码器
+	readBufferLen            int             // 所读取的客户端包的最大长度，客户端发送的包不能超过这个长度，默认值是1024字节
+	acceptGNum               int             // 处理接受请求的goroutine数量
+	ioGNum                   int             // 处理io的goroutine数量
+	ioEventQueueLen          int             // io事件队列长度
+	timeout                  time.Duration   // 超时时间
+	middlewares              []Middleware    // Handler中间件
+	proxyProtocol            bool            // 是否解析PROXY protocol头部
+	proxyProtocolStrict      bool            // 是否拒绝没有PROXY protocol头部或者头部非法的连接
+	connRateLimit            RateLimit       // 每个连接的限流参数
+	globalRateLimit          RateLimit       // 所有连接共享的限流参数
+	rateLimitPolicy          RateLimitPolicy // 超出限流之后的处理策略
+	writeBufferLowWatermark  int             // 写缓存区低水位
+	writeBufferHighWatermark int             // 写缓存区高水位
+	writeBufferLimit         int             // 写缓存区硬限制，为0表示不限制
+	ioUring                  bool            // 是否使用io_uring代替epoll
+	pollBatchSize            int             // 每次等待最多返回的事件数量
+	readBudgetBytes          int             // 每次读事件最多读取的字节数，为0表示不限制
+	readBudgetFrames         int             // 每次读事件最多处理的包数，为0表示不限制
+	lockOSThread             bool            // IO goroutine是否锁定到线程
+	cpus                     []int           // IO goroutine绑定的CPU
+	incomingCPU              bool            // 是否根据SO_INCOMING_CPU选择IO goroutine
+	loadBalance              LoadBalance     // 选择IO goroutine的策略
+	loadBalanceFunc          LoadBalanceFunc // 自定义的负载均衡策略
+	handoffConns             bool            // Upgrade时是否将空闲的连接移交给新进程
+	addresses                []string        // 除了NewServer的address之外，额外监听的地址
+	activationNames          []string        // 使用LISTEN_FDNAMES中这些名称的systemd监听文件描述符
 }
 
+// CodecFactory 编解码器工厂，为每个连接创建独立的解码器和编码器，用于有状态的编解码器
+type CodecFactory func(c *Conn) (codec.Decoder, codec.Encoder)
+
 type Option interface {
 	apply(*options)
 }
@@ -49,6 +75,20 @@ func WithEncoder(encoder codec.Encoder) Option {
 	})
 }
 
+// WithCodecFactory 设置编解码器工厂，连接建立时为每个连接创建解码器和编码器，优先于WithDecoder、WithEncoder
+func WithCodecFactory(factory CodecFactory) Option {
+	return newFuncServerOption(func(o *options) {
+		o.codecFactory = factory
+	})
+}
+
+// WithMessageCodec 设置消息编解码器，配合NewTypedHandler和Conn.Send使用
+func WithMessageCodec(messageCodec MessageCodec) Option {
+	return newFuncServerOption(func(o *options) {
+		o.messageCodec = messageCodec
+	})
+}
+
 // WithReadBufferLen 设置缓存区大小
 func WithReadBufferLen(len int) Option {
 	return newFuncServerOption(func(o *options) {
@@ -79,7 +119,8 @@ func WithIOGNum(num int) Option {
 	})
 }
 
-// WithIOEventQueueLen 设置IO事件队列长度，默认值是1024
+// WithIOEventQueueLen 设置IO事件队列长度，默认值是1024，写入IO事件队列不会阻塞，
+// 待处理的事件数超过该值时计入IOQueueStats.Saturated
 func WithIOEventQueueLen(num int) Option {
 	return newFuncServerOption(func(o *options) {
 		if num <= 0 {
@@ -100,6 +141,162 @@ func WithTimeout(timeout time.Duration) Option {
 	})
 }
 
+// WithMiddleware 设置Handler中间件，先添加的中间件在外层
+func WithMiddleware(middlewares ...Middleware) Option {
+	return newFuncServerOption(func(o *options) {
+		o.middlewares = append(o.middlewares, middlewares...)
+	})
+}
+
+// WithProxyProtocol 在连接的起始位置解析HAProxy PROXY protocol v1、v2头部，解析完成之后才回调OnConnect，
+// Conn.GetAddr返回客户端真实地址，Conn.GetProxyHeader返回完整的头部
+// strict 为true时，没有头部或者头部非法的连接会被关闭，否则当作没有头部处理
+func WithProxyProtocol(strict bool) Option {
+	return newFuncServerOption(func(o *options) {
+		o.proxyProtocol = true
+		o.proxyProtocolStrict = strict
+	})
+}
+
+// WithConnRateLimit 设置每个连接的限流参数，可以通过Conn.SetRateLimit调整单个连接
+func WithConnRateLimit(limit RateLimit) Option {
+	return newFuncServerOption(func(o *options) {
+		o.connRateLimit = limit
+	})
+}
+
+// WithGlobalRateLimit 设置所有连接共享的限流参数
+func WithGlobalRateLimit(limit RateLimit) Option {
+	return newFuncServerOption(func(o *options) {
+		o.globalRateLimit = limit
+	})
+}
+
+// WithRateLimitPolicy 设置超出限流之后的处理策略，默认值是RateLimitPause
+func WithRateLimitPolicy(policy RateLimitPolicy) Option {
+	return newFuncServerOption(func(o *options) {
+		o.rateLimitPolicy = policy
+	})
+}
+
+// WithWriteBufferWatermark 设置写缓存区的低水位和高水位，默认值是32KB和64KB，
+// 写缓存区超过高水位时Conn.IsWritable返回false，降到低水位以下时恢复，变化时回调WritabilityHandler
+func WithWriteBufferWatermark(low, high int) Option {
+	return newFuncServerOption(func(o *options) {
+		if low < 0 || high <= low {
+			panic("high must greater than low and low must not less than 0")
+		}
+		o.writeBufferLowWatermark = low
+		o.writeBufferHighWatermark = high
+	})
+}
+
+// WithWriteBufferLimit 设置写缓存区的硬限制，写缓存区超过limit时以ErrWriteBufferOverflow关闭连接，默认不限制
+func WithWriteBufferLimit(limit int) Option {
+	return newFuncServerOption(func(o *options) {
+		if limit <= 0 {
+			panic("limit must greater than 0")
+		}
+		o.writeBufferLimit = limit
+	})
+}
+
+// WithPollBatchSize 设置每次epoll_wait、kevent最多返回的事件数量，默认值是128
+func WithPollBatchSize(size int) Option {
+	return newFuncServerOption(func(o *options) {
+		if size <= 0 {
+			panic("pollBatchSize must greater than 0")
+		}
+		o.pollBatchSize = size
+	})
+}
+
+// WithReadBudget 设置每次读事件的预算，一个连接读取的字节数超过bytes或者处理的包数超过frames之后，
+// 重新放入IO事件队列的尾部，防止一个持续发送数据的连接占用IO goroutine，为0表示不限制，默认不限制
+func WithReadBudget(bytes, frames int) Option {
+	return newFuncServerOption(func(o *options) {
+		if bytes < 0 || frames < 0 {
+			panic("bytes and frames must not less than 0")
+		}
+		o.readBudgetBytes = bytes
+		o.readBudgetFrames = frames
+	})
+}
+
+// WithLockOSThread 使用runtime.LockOSThread将生产者以及每个IO goroutine锁定到独立的线程
+func WithLockOSThread() Option {
+	return newFuncServerOption(func(o *options) {
+		o.lockOSThread = true
+	})
+}
+
+// WithCPUAffinity 使用sched_setaffinity将第i个IO goroutine绑定到cpus[i%len(cpus)]，生产者绑定到cpus中所有的CPU，
+// 同时开启WithLockOSThread，仅支持Linux
+func WithCPUAffinity(cpus ...int) Option {
+	return newFuncServerOption(func(o *options) {
+		if len(cpus) == 0 {
+			panic("cpus must not be empty")
+		}
+		o.lockOSThread = true
+		o.cpus = cpus
+	})
+}
+
+// WithIncomingCPU 连接建立时，根据SO_INCOMING_CPU将连接分配给绑定在接收该连接数据的CPU上的IO goroutine，
+// 配合网卡RSS队列的中断绑定使用，没有设置WithCPUAffinity时按照CPU编号取模，仅支持Linux
+func WithIncomingCPU() Option {
+	return newFuncServerOption(func(o *options) {
+		o.incomingCPU = true
+	})
+}
+
+// WithLoadBalance 设置连接建立时选择IO goroutine的策略，默认值是LoadBalanceFD，可以通过Server.GetLoopConnsNum观察是否均衡
+func WithLoadBalance(lb LoadBalance) Option {
+	return newFuncServerOption(func(o *options) {
+		o.loadBalance = lb
+	})
+}
+
+// WithLoadBalanceFunc 设置自定义的负载均衡策略，优先于WithLoadBalance
+func WithLoadBalanceFunc(f LoadBalanceFunc) Option {
+	return newFuncServerOption(func(o *options) {
+		o.loadBalanceFunc = f
+	})
+}
+
+// WithAddresses 除了NewServer的address之外，额外监听的地址，例如同时监听内网、外网地址或者IPv4、IPv6地址，
+// 所有地址共享Handler、参数、内存池以及IO goroutine，Conn.LocalAddr获取连接的本端地址
+func WithAddresses(addresses ...string) Option {
+	return newFuncServerOption(func(o *options) {
+		o.addresses = append(o.addresses, addresses...)
+	})
+}
+
+// WithActivationNames 由systemd socket activation启动时，只使用LISTEN_FDNAMES中名称匹配的监听文件描述符，
+// 默认使用systemd传递的所有监听文件描述符，没有通过LISTEN_FDS传递时监听NewServer的address
+func WithActivationNames(names ...string) Option {
+	return newFuncServerOption(func(o *options) {
+		o.activationNames = append(o.activationNames, names...)
+	})
+}
+
+// WithHandoffConns Upgrade时通过SCM_RIGHTS将空闲的连接移交给新进程，新进程回调OnConnect，旧进程以ErrConnHandedOff回调OnClose，
+// 连接上的业务数据、PROXY protocol头部不会移交
+func WithHandoffConns() Option {
+	return newFuncServerOption(func(o *options) {
+		o.handoffConns = true
+	})
+}
+
+// WithIOUring 在Linux上使用io_uring代替epoll，使用multishot accept接收连接，从provided buffer ring中选择缓存区接收数据，
+// 异步发送写缓存区中的数据，每个IO goroutine处理完一批事件之后批量提交请求，需要5.19及以上的内核，
+// 内核不支持或者io_uring被禁用时自动回退到epoll，其他平台忽略该参数
+func WithIOUring() Option {
+	return newFuncServerOption(func(o *options) {
+		o.ioUring = true
+	})
+}
+
 func getOptions(opts ...Option) *options {
 	cpuNum := runtime.NumCPU()
 	options := &options{
@@ -107,6 +304,10 @@ func getOptions(opts ...Option) *options {
 		acceptGNum:      cpuNum,
 		ioGNum:          cpuNum,
 		ioEventQueueLen: 1024,
+		pollBatchSize:   128,
+
+		writeBufferLowWatermark:  32 * 1024,
+		writeBufferHighWatermark: 64 * 1024,
 	}
 
 	for _, o := range opts {
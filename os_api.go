@@ -1,8 +1,22 @@
 package gn
 
 type netpoll interface {
-	accept() (nfd int, addr string, err error)
+	accept() (nfd int, addr string, err error) // 接收连接，不监听读事件，没有新连接时最多等待acceptTimeout，超时返回EAGAIN
+	listeners() []int                          // 监听的文件描述符
+	stopAccept()                               // 停止接收连接，在accept goroutine退出之后调用，可以重复调用
+	add(fd int) error                          // 监听文件描述符的读事件
 	closeFD(fd int) error
-	getEvents() ([]event, error)
+	wait(handle func(e event)) error // 等待事件，对每个事件调用handle，复用事件数组，不产生中间切片
 	closeFDRead(fd int) error
+	modify(fd int, read, write bool) error // 修改监听的读写事件
+	read(fd int, p []byte) (int, error)    // 读取数据，io_uring先读取已经接收到provided buffer ring中的数据
+	direct(fd int) bool                    // 之后由调用方直接读取socket（splice、移交连接），等待尚未完成的异步发送，返回是否没有已经接收但尚未读取的数据
+	batchStart()                           // IO goroutine开始处理一批事件，期间提交的io_uring请求延迟到batchEnd批量提交
+	batchEnd()                             // IO goroutine处理完一批事件，提交延迟的请求
+}
+
+// asyncSender 异步发送数据的netpoll实现（io_uring），发送完成之后产生EventOut事件
+type asyncSender interface {
+	send(fd int, buffers [][]byte) error          // 提交发送请求，完成之前不能修改buffers中的数据
+	sent(fd int) (n int, pending bool, err error) // 获取上次发送的字节数以及错误，pending表示发送尚未完成
 }
@@ -0,0 +1,248 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotProxy      = errors.New("not proxy protocol header")
+	ErrInvalidHeader = errors.New("invalid proxy protocol header")
+)
+
+const (
+	v1MaxLen = 107 // v1头部的最大长度，包括\r\n
+	v2MinLen = 16  // v2头部固定部分的长度
+)
+
+var (
+	v1Signature = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// 命令
+const (
+	CommandLocal = 0x0 // 负载均衡器自身发起的连接，例如健康检查，没有地址信息
+	CommandProxy = 0x1 // 代理的连接
+)
+
+// TLV v2头部中的扩展字段
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// TLV类型
+const (
+	TLVTypeALPN      = 0x01
+	TLVTypeAuthority = 0x02
+	TLVTypeCRC32C    = 0x03
+	TLVTypeNoop      = 0x04
+	TLVTypeUniqueID  = 0x05
+	TLVTypeSSL       = 0x20
+	TLVTypeNetNS     = 0x30
+)
+
+// Header PROXY protocol头部
+type Header struct {
+	Version  int      // 版本，1或者2
+	Command  byte     // 命令，v1总是CommandProxy
+	Protocol string   // 协议，TCP4、TCP6、UDP4、UDP6、UNIX、UNKNOWN
+	SrcAddr  net.Addr // 源地址，即客户端真实地址，未知时为nil
+	DstAddr  net.Addr // 目的地址，未知时为nil
+	TLVs     []TLV    // v2头部中的扩展字段
+}
+
+// Parse 从连接的起始字节中解析PROXY protocol头部，返回头部以及头部占用的字节数
+// 字节不足以判断时返回nil, 0, nil；不是PROXY protocol头部时返回ErrNotProxy
+func Parse(buf []byte) (*Header, int, error) {
+	switch {
+	case hasPrefix(buf, v2Signature):
+		if len(buf) < len(v2Signature) {
+			return nil, 0, nil
+		}
+		return parseV2(buf)
+	case hasPrefix(buf, v1Signature):
+		if len(buf) < len(v1Signature) {
+			return nil, 0, nil
+		}
+		return parseV1(buf)
+	}
+	return nil, 0, ErrNotProxy
+}
+
+// hasPrefix buf以prefix开头，或者buf是prefix的前缀
+func hasPrefix(buf, prefix []byte) bool {
+	if len(buf) < len(prefix) {
+		return bytes.HasPrefix(prefix, buf)
+	}
+	return bytes.HasPrefix(buf, prefix)
+}
+
+// parseV1 解析v1文本头部，例如 PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func parseV1(buf []byte) (*Header, int, error) {
+	end := bytes.Index(buf, []byte("\r\n"))
+	if end < 0 {
+		if len(buf) >= v1MaxLen {
+			return nil, 0, ErrInvalidHeader
+		}
+		return nil, 0, nil
+	}
+	if end+2 > v1MaxLen {
+		return nil, 0, ErrInvalidHeader
+	}
+
+	h := &Header{Version: 1, Command: CommandProxy}
+	fields := strings.Split(string(buf[:end]), " ")
+	if len(fields) < 2 {
+		return nil, 0, ErrInvalidHeader
+	}
+	h.Protocol = fields[1]
+	switch h.Protocol {
+	case "UNKNOWN":
+		return h, end + 2, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, 0, ErrInvalidHeader
+	}
+	if len(fields) != 6 {
+		return nil, 0, ErrInvalidHeader
+	}
+
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	if srcIP == nil || dstIP == nil || (srcIP.To4() != nil) != (h.Protocol == "TCP4") ||
+		(dstIP.To4() != nil) != (h.Protocol == "TCP4") {
+		return nil, 0, ErrInvalidHeader
+	}
+	srcPort, err := parsePort(fields[4])
+	if err != nil {
+		return nil, 0, err
+	}
+	dstPort, err := parsePort(fields[5])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	h.SrcAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+	h.DstAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	return h, end + 2, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 || (len(s) > 1 && s[0] == '0') {
+		return 0, ErrInvalidHeader
+	}
+	return port, nil
+}
+
+// parseV2 解析v2二进制头部
+func parseV2(buf []byte) (*Header, int, error) {
+	if len(buf) < v2MinLen {
+		return nil, 0, nil
+	}
+	if buf[12]>>4 != 2 {
+		return nil, 0, ErrInvalidHeader
+	}
+	length := int(binary.BigEndian.Uint16(buf[14:16]))
+	if len(buf) < v2MinLen+length {
+		return nil, 0, nil
+	}
+
+	h := &Header{Version: 2, Command: buf[12] & 0x0f}
+	if h.Command != CommandLocal && h.Command != CommandProxy {
+		return nil, 0, ErrInvalidHeader
+	}
+
+	payload := buf[v2MinLen : v2MinLen+length]
+	family, transport := buf[13]>>4, buf[13]&0x0f
+	var addrLen int
+	switch family {
+	case 0x0:
+		h.Protocol = "UNKNOWN"
+	case 0x1:
+		addrLen = 12
+		h.Protocol = "TCP4"
+	case 0x2:
+		addrLen = 36
+		h.Protocol = "TCP6"
+	case 0x3:
+		addrLen = 216
+		h.Protocol = "UNIX"
+	default:
+		return nil, 0, ErrInvalidHeader
+	}
+	if transport == 0x2 && (family == 0x1 || family == 0x2) {
+		h.Protocol = strings.Replace(h.Protocol, "TCP", "UDP", 1)
+	}
+	if len(payload) < addrLen {
+		return nil, 0, ErrInvalidHeader
+	}
+
+	// LOCAL命令需要忽略地址信息
+	if h.Command == CommandProxy {
+		switch family {
+		case 0x1, 0x2:
+			ipLen := (addrLen - 4) / 2
+			srcIP := net.IP(append([]byte(nil), payload[:ipLen]...))
+			dstIP := net.IP(append([]byte(nil), payload[ipLen:2*ipLen]...))
+			srcPort := int(binary.BigEndian.Uint16(payload[2*ipLen:]))
+			dstPort := int(binary.BigEndian.Uint16(payload[2*ipLen+2:]))
+			if transport == 0x2 {
+				h.SrcAddr = &net.UDPAddr{IP: srcIP, Port: srcPort}
+				h.DstAddr = &net.UDPAddr{IP: dstIP, Port: dstPort}
+			} else {
+				h.SrcAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+				h.DstAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+			}
+		case 0x3:
+			h.SrcAddr = &net.UnixAddr{Name: unixPath(payload[:108]), Net: "unix"}
+			h.DstAddr = &net.UnixAddr{Name: unixPath(payload[108:216]), Net: "unix"}
+		}
+	}
+
+	tlvs, err := parseTLVs(payload[addrLen:])
+	if err != nil {
+		return nil, 0, err
+	}
+	h.TLVs = tlvs
+	return h, v2MinLen + length, nil
+}
+
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// parseTLVs 解析扩展字段，返回的Value是拷贝
+func parseTLVs(buf []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(buf) > 0 {
+		if len(buf) < 3 {
+			return nil, ErrInvalidHeader
+		}
+		length := int(binary.BigEndian.Uint16(buf[1:3]))
+		if len(buf) < 3+length {
+			return nil, ErrInvalidHeader
+		}
+		tlvs = append(tlvs, TLV{Type: buf[0], Value: append([]byte(nil), buf[3:3+length]...)})
+		buf = buf[3+length:]
+	}
+	return tlvs, nil
+}
+
+// GetTLV 获取指定类型的扩展字段
+func (h *Header) GetTLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,54 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseV1(t *testing.T) {
+	raw := []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET /")
+	for i := 0; i < 47; i++ {
+		h, n, err := Parse(raw[:i])
+		if h != nil || n != 0 || err != nil {
+			t.Fatal(i, h, n, err)
+		}
+	}
+	h, n, err := Parse(raw)
+	if err != nil || n != 47 || h.SrcAddr.String() != "192.168.0.1:56324" || h.DstAddr.String() != "192.168.0.11:443" {
+		t.Fatal(h, n, err)
+	}
+
+	_, _, err = Parse([]byte("PROXY TCP4 192.168.0.1 ::1 56324 443\r\n"))
+	if err != ErrInvalidHeader {
+		t.Fatal(err)
+	}
+	_, _, err = Parse([]byte("GET / HTTP/1.1\r\n"))
+	if err != ErrNotProxy {
+		t.Fatal(err)
+	}
+}
+
+func TestParseV2(t *testing.T) {
+	raw := append([]byte(nil), v2Signature...)
+	raw = append(raw, 0x21, 0x11, 0, 0)
+	raw = append(raw, 10, 0, 0, 1, 10, 0, 0, 2, 0x1f, 0x90, 0x01, 0xbb)
+	raw = append(raw, TLVTypeAuthority, 0, 7)
+	raw = append(raw, "example"...)
+	binary.BigEndian.PutUint16(raw[14:], uint16(len(raw)-16))
+
+	_, n, err := Parse(raw[:len(raw)-1])
+	if n != 0 || err != nil {
+		t.Fatal(n, err)
+	}
+	h, n, err := Parse(append(raw, "data"...))
+	if err != nil || n != len(raw) || h.Protocol != "TCP4" {
+		t.Fatal(h, n, err)
+	}
+	if h.SrcAddr.String() != "10.0.0.1:8080" || h.DstAddr.String() != "10.0.0.2:443" {
+		t.Fatal(h.SrcAddr, h.DstAddr)
+	}
+	authority, ok := h.GetTLV(TLVTypeAuthority)
+	if !ok || string(authority) != "example" {
+		t.Fatal(h.TLVs)
+	}
+}
@@ -0,0 +1,196 @@
+package gn
+
+import (
+	"errors"
+	"github.com/alberliu/gn/internal/ratelimit"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitPolicy 超出限流之后的处理策略
+type RateLimitPolicy int
+
+const (
+	RateLimitPause RateLimitPolicy = iota // 暂停读取（从epoll中移除读事件），令牌恢复之后继续读取
+	RateLimitDrop                         // 丢弃超出限流的包
+	RateLimitClose                        // 以ErrRateLimited关闭连接
+)
+
+// RateLimit 限流参数，为0的字段表示不限制
+type RateLimit struct {
+	MessagesPerSecond float64 // 每秒允许的包数
+	MessageBurst      int     // 允许突发的包数，为0时等于MessagesPerSecond
+	BytesPerSecond    float64 // 每秒允许的字节数
+	BytesBurst        int     // 允许突发的字节数，为0时等于BytesPerSecond，超过BytesBurst的包在桶满时通过，之后透支
+}
+
+// rateLimiter 包数以及字节数的令牌桶，为nil的令牌桶表示不限制
+type rateLimiter struct {
+	lock     sync.Mutex // 保证allow检查和取走令牌的原子性，全局限流器被所有IO goroutine共享
+	messages *ratelimit.Bucket
+	bytes    *ratelimit.Bucket
+}
+
+// newRateLimiter 创建限流器，不限制时返回nil
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.MessagesPerSecond <= 0 && limit.BytesPerSecond <= 0 {
+		return nil
+	}
+
+	l := &rateLimiter{}
+	if limit.MessagesPerSecond > 0 {
+		l.messages = ratelimit.NewBucket(limit.MessagesPerSecond, getBurst(limit.MessagesPerSecond, limit.MessageBurst))
+	}
+	if limit.BytesPerSecond > 0 {
+		l.bytes = ratelimit.NewBucket(limit.BytesPerSecond, getBurst(limit.BytesPerSecond, limit.BytesBurst))
+	}
+	return l
+}
+
+func getBurst(rate float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return int(math.Max(1, math.Ceil(rate)))
+}
+
+// ready 返回令牌是否足够取走1个包以及n个字节，不取走令牌，
+// n超过字节数的突发值时，桶满即可，之后透支，防止大包永远无法通过
+func (l *rateLimiter) ready(n int) bool {
+	if l == nil {
+		return true
+	}
+	if l.messages != nil && !l.messages.Ready(1) {
+		return false
+	}
+	return l.bytes == nil || l.bytes.Ready(n)
+}
+
+// allow 令牌足够时取走1个包以及n个字节的令牌，检查和取走在同一个锁中，并发调用时不会多次透支
+func (l *rateLimiter) allow(n int) bool {
+	if l == nil {
+		return true
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.ready(n) {
+		return false
+	}
+	l.take(1, n)
+	return true
+}
+
+// take 取走令牌，令牌不足时透支
+func (l *rateLimiter) take(messages, bytes int) {
+	if l == nil {
+		return
+	}
+	if l.messages != nil && messages > 0 {
+		l.messages.Take(messages)
+	}
+	if l.bytes != nil && bytes > 0 {
+		l.bytes.Take(bytes)
+	}
+}
+
+// delay 返回令牌恢复还需要等待的时间
+func (l *rateLimiter) delay() time.Duration {
+	if l == nil {
+		return 0
+	}
+	var delay time.Duration
+	if l.messages != nil {
+		delay = l.messages.Delay()
+	}
+	if l.bytes != nil {
+		if d := l.bytes.Delay(); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// SetRateLimit 调整连接的限流参数，例如在鉴权之后放宽限制
+func (c *Conn) SetRateLimit(limit RateLimit) {
+	c.limiter.Store(newRateLimiter(limit))
+}
+
+// getRateLimiter 获取连接的限流器
+func (c *Conn) getRateLimiter() *rateLimiter {
+	return c.limiter.Load().(*rateLimiter)
+}
+
+// allowMessage 对包进行限流，返回是否交给Handler处理
+func (c *Conn) allowMessage(bytes []byte) bool {
+	limiter, global := c.getRateLimiter(), c.server.limiter
+	if limiter == nil && global == nil {
+		return true
+	}
+
+	if c.server.options.rateLimitPolicy == RateLimitPause {
+		// 字节数在读取时已经计算
+		limiter.take(1, 0)
+		global.take(1, 0)
+		return true
+	}
+
+	// 连接的限流器只在连接的IO goroutine中使用，先检查连接的令牌，通过之后再对全局限流器原子地检查并取走令牌，
+	// 被任意一个限流器拒绝的包都不消耗令牌
+	if limiter.ready(len(bytes)) && global.allow(len(bytes)) {
+		limiter.take(1, len(bytes))
+		return true
+	}
+	if c.server.options.rateLimitPolicy == RateLimitClose {
+		c.CloseWithError(ErrRateLimited)
+	}
+	return false
+}
+
+// takeReadBytes 暂停读取策略下，读取之后计算字节数
+func (c *Conn) takeReadBytes(n int) {
+	if c.server.options.rateLimitPolicy != RateLimitPause {
+		return
+	}
+	c.getRateLimiter().take(0, n)
+	c.server.limiter.take(0, n)
+}
+
+// pauseIfLimited 暂停读取策略下，令牌不足时暂停读取，令牌恢复之后继续读取
+func (c *Conn) pauseIfLimited() bool {
+	if atomic.LoadInt32(&c.paused) == 1 {
+		return true
+	}
+	if c.server.options.rateLimitPolicy != RateLimitPause {
+		return false
+	}
+
+	delay := c.getRateLimiter().delay()
+	if d := c.server.limiter.delay(); d > delay {
+		delay = d
+	}
+	if delay == 0 {
+		return false
+	}
+
+	atomic.StoreInt32(&c.paused, 1)
+	err := c.setReadInterest(false)
+	if err != nil {
+		log.Error(err)
+	}
+	time.AfterFunc(delay, c.resumeRead)
+	return true
+}
+
+// resumeRead 恢复读取，重新注册读事件之后，如果有数据可读，会产生新的读事件，
+// 在定时器的goroutine中执行，连接已经关闭时setReadInterest不会修改监听事件
+func (c *Conn) resumeRead() {
+	atomic.StoreInt32(&c.paused, 0)
+	err := c.setReadInterest(true)
+	if err != nil {
+		log.Error(err)
+	}
+}
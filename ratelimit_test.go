@@ -0,0 +1,30 @@
+package gn
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	// 全局限流器被多个IO goroutine并发使用，通过的包数不能超过突发值
+	limiter := newRateLimiter(RateLimit{MessagesPerSecond: 0.001, MessageBurst: 1000, BytesPerSecond: 0.001, BytesBurst: 10000})
+
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if limiter.allow(1) {
+					atomic.AddInt32(&allowed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 1000 {
+		t.Fatal(allowed)
+	}
+}
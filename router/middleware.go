@@ -0,0 +1,102 @@
+package router
+
+import (
+	"fmt"
+	"github.com/alberliu/gn"
+	"github.com/alberliu/gn/internal/ratelimit"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Recover 捕获处理函数中的panic，打印堆栈之后丢弃该消息
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *gn.Conn, cmd uint32, body []byte) {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Error(fmt.Sprintf("command %d panic: %v\n%s", cmd, err, debug.Stack()))
+				}
+			}()
+			next(c, cmd, body)
+		}
+	}
+}
+
+// Logging 打印每个命令的处理耗时
+func Logging() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *gn.Conn, cmd uint32, body []byte) {
+			start := time.Now()
+			next(c, cmd, body)
+			log.Debug(fmt.Sprintf("fd:%d cmd:%d len:%d cost:%s", c.GetFd(), cmd, len(body), time.Since(start)))
+		}
+	}
+}
+
+// Auth 鉴权，allow返回false的消息会被丢弃，例如只放行登录命令以及已经登录的连接
+func Auth(allow func(c *gn.Conn, cmd uint32) bool) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *gn.Conn, cmd uint32, body []byte) {
+			if !allow(c, cmd) {
+				log.Debug(fmt.Sprintf("fd:%d cmd:%d unauthorized", c.GetFd(), cmd))
+				return
+			}
+			next(c, cmd, body)
+		}
+	}
+}
+
+var (
+	closeHooksLock sync.RWMutex
+	closeHooks     []func(c *gn.Conn)
+)
+
+// onConnClose 注册连接关闭时的回调，中间件用于清理每个连接的状态，Router.OnClose时回调
+func onConnClose(hook func(c *gn.Conn)) {
+	closeHooksLock.Lock()
+	defer closeHooksLock.Unlock()
+	closeHooks = append(closeHooks, hook)
+}
+
+// connClosed 回调所有中间件注册的连接关闭回调
+func connClosed(c *gn.Conn) {
+	closeHooksLock.RLock()
+	defer closeHooksLock.RUnlock()
+	for _, hook := range closeHooks {
+		hook(c)
+	}
+}
+
+// RateLimit 对每个连接的消息数进行限流，超出的消息会被丢弃，连接关闭时（Router.OnClose）释放令牌桶
+// rate 每秒允许的消息数
+// burst 允许的突发消息数
+func RateLimit(rate float64, burst int) Middleware {
+	var (
+		lock    sync.Mutex
+		buckets = make(map[*gn.Conn]*ratelimit.Bucket)
+	)
+	onConnClose(func(c *gn.Conn) {
+		lock.Lock()
+		delete(buckets, c)
+		lock.Unlock()
+	})
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *gn.Conn, cmd uint32, body []byte) {
+			lock.Lock()
+			b, ok := buckets[c]
+			if !ok {
+				b = ratelimit.NewBucket(rate, burst)
+				buckets[c] = b
+			}
+			lock.Unlock()
+
+			if !b.Allow(1) {
+				log.Debug(fmt.Sprintf("fd:%d cmd:%d rate limited", c.GetFd(), cmd))
+				return
+			}
+			next(c, cmd, body)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package router
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/alberliu/gn"
+	"sync"
+)
+
+var log = gn.GetLogger()
+
+var ErrIllegalHeader = errors.New("illegal command header")
+
+// HandlerFunc 命令处理函数，body只在调用期间有效
+type HandlerFunc func(c *gn.Conn, cmd uint32, body []byte)
+
+// Middleware 中间件，对命令处理函数进行包装
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// KeyFunc 从包中解析出命令ID以及包体
+type KeyFunc func(bytes []byte) (cmd uint32, body []byte, err error)
+
+// HeaderKey 使用包头部固定长度的字段（大端序）作为命令ID，剩余部分作为包体
+// size 命令ID的字节长度，只能是1、2、4
+func HeaderKey(size int) KeyFunc {
+	if size != 1 && size != 2 && size != 4 {
+		panic("size must be 1, 2 or 4")
+	}
+
+	return func(bytes []byte) (uint32, []byte, error) {
+		if len(bytes) < size {
+			return 0, nil, ErrIllegalHeader
+		}
+
+		var cmd uint32
+		switch size {
+		case 1:
+			cmd = uint32(bytes[0])
+		case 2:
+			cmd = uint32(binary.BigEndian.Uint16(bytes))
+		case 4:
+			cmd = binary.BigEndian.Uint32(bytes)
+		}
+		return cmd, bytes[size:], nil
+	}
+}
+
+// Router 基于命令ID的消息路由，实现了gn.Handler
+type Router struct {
+	keyFunc     KeyFunc
+	lock        sync.RWMutex
+	handlers    map[uint32]HandlerFunc      // 注册的命令处理函数
+	fallback    HandlerFunc                 // 未注册命令的处理函数
+	middlewares []Middleware                // 中间件
+	chain       HandlerFunc                 // 使用中间件包装后的分发函数
+	onConnect   func(c *gn.Conn)            // 连接建立回调
+	onClose     func(c *gn.Conn, err error) // 连接关闭回调
+}
+
+// NewRouter 创建路由
+func NewRouter(keyFunc KeyFunc) *Router {
+	if keyFunc == nil {
+		panic("keyFunc must not be nil")
+	}
+
+	r := &Router{
+		keyFunc:  keyFunc,
+		handlers: make(map[uint32]HandlerFunc),
+		fallback: func(c *gn.Conn, cmd uint32, body []byte) {
+			log.Error(fmt.Sprintf("unknown command %d from %s", cmd, c.GetAddr()))
+		},
+	}
+	r.chain = r.dispatch
+	return r
+}
+
+// Handle 注册命令处理函数
+func (r *Router) Handle(cmd uint32, handler HandlerFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.handlers[cmd] = handler
+}
+
+// Fallback 设置未注册命令的处理函数，默认打印错误日志并丢弃
+func (r *Router) Fallback(handler HandlerFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.fallback = handler
+}
+
+// Use 添加中间件，先添加的中间件在外层
+func (r *Router) Use(middlewares ...Middleware) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.middlewares = append(r.middlewares, middlewares...)
+	chain := r.dispatch
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		chain = r.middlewares[i](chain)
+	}
+	r.chain = chain
+}
+
+// SetConnectHandler 设置连接建立回调
+func (r *Router) SetConnectHandler(onConnect func(c *gn.Conn)) {
+	r.onConnect = onConnect
+}
+
+// SetCloseHandler 设置连接关闭回调
+func (r *Router) SetCloseHandler(onClose func(c *gn.Conn, err error)) {
+	r.onClose = onClose
+}
+
+// OnConnect 当TCP长连接建立成功是回调
+func (r *Router) OnConnect(c *gn.Conn) {
+	if r.onConnect != nil {
+		r.onConnect(c)
+	}
+}
+
+// OnMessage 解析命令ID，经过中间件之后分发给对应的处理函数
+func (r *Router) OnMessage(c *gn.Conn, bytes []byte) {
+	cmd, body, err := r.keyFunc(bytes)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	r.lock.RLock()
+	chain := r.chain
+	r.lock.RUnlock()
+	chain(c, cmd, body)
+}
+
+// OnClose 当客户端主动断开链接或者超时时回调，先清理中间件保存的连接状态
+func (r *Router) OnClose(c *gn.Conn, err error) {
+	connClosed(c)
+	if r.onClose != nil {
+		r.onClose(c, err)
+	}
+}
+
+// dispatch 分发给命令对应的处理函数
+func (r *Router) dispatch(c *gn.Conn, cmd uint32, body []byte) {
+	r.lock.RLock()
+	handler, ok := r.handlers[cmd]
+	if !ok {
+		handler = r.fallback
+	}
+	r.lock.RUnlock()
+
+	handler(c, cmd, body)
+}
@@ -0,0 +1,63 @@
+package router
+
+import (
+	"github.com/alberliu/gn"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	var trace []string
+	mark := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(c *gn.Conn, cmd uint32, body []byte) {
+				trace = append(trace, name)
+				next(c, cmd, body)
+			}
+		}
+	}
+
+	r := NewRouter(HeaderKey(2))
+	r.Use(mark("a"), mark("b"))
+	r.Handle(1, func(c *gn.Conn, cmd uint32, body []byte) {
+		trace = append(trace, "1:"+string(body))
+	})
+	r.Fallback(func(c *gn.Conn, cmd uint32, body []byte) {
+		trace = append(trace, "fallback")
+	})
+
+	r.OnMessage(nil, []byte{0, 1, 'h', 'i'})
+	r.OnMessage(nil, []byte{0, 2})
+	r.OnMessage(nil, []byte{0})
+
+	expect := []string{"a", "b", "1:hi", "a", "b", "fallback"}
+	if len(trace) != len(expect) {
+		t.Fatal(trace)
+	}
+	for i := range expect {
+		if trace[i] != expect[i] {
+			t.Fatal(trace)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	count := 0
+	r := NewRouter(HeaderKey(1))
+	r.Use(RateLimit(0.001, 1))
+	r.Handle(1, func(c *gn.Conn, cmd uint32, body []byte) {
+		count++
+	})
+
+	c := &gn.Conn{}
+	r.OnMessage(c, []byte{1})
+	r.OnMessage(c, []byte{1})
+	if count != 1 {
+		t.Fatal(count)
+	}
+	// 连接关闭时释放令牌桶
+	r.OnClose(c, nil)
+	r.OnMessage(c, []byte{1})
+	if count != 2 {
+		t.Fatal(count)
+	}
+}
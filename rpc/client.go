@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/alberliu/gn/codec"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrClosed = errors.New("rpc client closed")
+
+// Error 服务端返回的错误
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+type response struct {
+	body []byte
+	err  error
+}
+
+// Client RPC客户端，支持并发调用，响应可以乱序返回
+type Client struct {
+	write   func(frame []byte) error // 发送帧，由编码器负责拆包粘包
+	closer  io.Closer                // 底层连接
+	seq     uint32                   // 最近一次分配的序列号
+	lock    sync.Mutex
+	pending map[uint32]chan *response // 等待响应的调用
+	err     error                     // 关闭原因，不为nil时客户端已关闭
+}
+
+// NewClient 创建RPC客户端，write用来发送帧，收到的帧需要交给Receive处理
+func NewClient(write func(frame []byte) error) *Client {
+	return &Client{
+		write:   write,
+		pending: make(map[uint32]chan *response),
+	}
+}
+
+// NewNetClient 基于net.Conn创建RPC客户端，会启动一个goroutine读取响应
+// readBufferLen 所读取的服务端包的最大长度
+func NewNetClient(conn net.Conn, decoder codec.Decoder, encoder codec.Encoder, readBufferLen int) *Client {
+	c := NewClient(func(frame []byte) error {
+		return encoder.EncodeToWriter(conn, frame)
+	})
+	c.closer = conn
+
+	go func() {
+		buffer := codec.NewBuffer(make([]byte, readBufferLen))
+		for {
+			_, err := buffer.ReadFromReader(conn)
+			if err == nil {
+				err = decoder.Decode(buffer, c.Receive)
+			}
+			if err != nil {
+				c.shutdown(err)
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// Call 发起调用，直到收到响应、ctx结束或者客户端关闭时返回
+func (c *Client) Call(ctx context.Context, method string, req []byte) ([]byte, error) {
+	if len(method) == 0 || len(method) > 255 {
+		return nil, errors.New("method length must between 1 and 255")
+	}
+
+	seq := atomic.AddUint32(&c.seq, 1)
+	ch := make(chan *response, 1)
+
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return nil, c.err
+	}
+	c.pending[seq] = ch
+	c.lock.Unlock()
+
+	err := c.write(encodeFrame(seq, kindRequest, method, req))
+	if err != nil {
+		c.remove(seq)
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp.body, resp.err
+	case <-ctx.Done():
+		c.remove(seq)
+		return nil, ctx.Err()
+	}
+}
+
+// Receive 处理收到的帧，非响应帧会被丢弃
+func (c *Client) Receive(bytes []byte) {
+	f, err := decodeFrame(bytes)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if f.kind == kindRequest {
+		log.Error("rpc client unexpected request ", f.method)
+		return
+	}
+	c.receive(f)
+}
+
+// Close 关闭客户端，所有等待中的调用返回ErrClosed
+func (c *Client) Close() error {
+	c.shutdown(ErrClosed)
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+func (c *Client) receive(f *frame) {
+	ch := c.remove(f.seq)
+	if ch == nil {
+		// 调用已经超时或者取消
+		return
+	}
+
+	// 包体引用读缓存区，需要制作拷贝
+	resp := &response{}
+	if f.kind == kindError {
+		resp.err = Error(f.body)
+	} else {
+		resp.body = make([]byte, len(f.body))
+		copy(resp.body, f.body)
+	}
+	ch <- resp
+}
+
+func (c *Client) remove(seq uint32) chan *response {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ch := c.pending[seq]
+	delete(c.pending, seq)
+	return ch
+}
+
+func (c *Client) shutdown(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	for seq, ch := range c.pending {
+		ch <- &response{err: err}
+		delete(c.pending, seq)
+	}
+}
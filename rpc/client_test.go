@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"github.com/alberliu/gn/codec"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	decoder := codec.NewUvarintDecoder()
+	encoder := codec.NewUvarintEncoder(1024)
+
+	clientConn, serverConn := net.Pipe()
+	client := NewNetClient(clientConn, decoder, encoder, 1024)
+	defer client.Close()
+
+	// 服务端收到两个请求后乱序响应，忽略sleep方法
+	go func() {
+		buffer := codec.NewBuffer(make([]byte, 1024))
+		var frames []*frame
+		for len(frames) < 3 {
+			_, err := buffer.ReadFromReader(serverConn)
+			if err != nil {
+				return
+			}
+			decoder.Decode(buffer, func(bytes []byte) {
+				f, err := decodeFrame(bytes)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				f.body = append([]byte(nil), f.body...)
+				frames = append(frames, f)
+			})
+		}
+		for i := len(frames) - 1; i >= 0; i-- {
+			f := frames[i]
+			switch f.method {
+			case "echo":
+				encoder.EncodeToWriter(serverConn, encodeFrame(f.seq, kindResponse, "", f.body))
+			case "fail":
+				encoder.EncodeToWriter(serverConn, encodeFrame(f.seq, kindError, "", []byte("failed")))
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.Call(ctx, "sleep", nil)
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_, err := client.Call(context.Background(), "fail", nil)
+		if err != Error("failed") {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	resp, err := client.Call(context.Background(), "echo", []byte("hello"))
+	if err != nil || string(resp) != "hello" {
+		t.Fatal(string(resp), err)
+	}
+	<-done
+
+	client.Close()
+	_, err = client.Call(context.Background(), "echo", nil)
+	if err != ErrClosed {
+		t.Fatal(err)
+	}
+}
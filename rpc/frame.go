@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	kindRequest  = 0 // 请求
+	kindResponse = 1 // 正常响应
+	kindError    = 2 // 错误响应，包体为错误信息
+
+	headerLen = 6 // 帧头长度：4字节序列号 + 1字节帧类型 + 1字节方法名长度
+)
+
+var ErrIllegalFrame = errors.New("illegal rpc frame")
+
+// frame RPC帧，作为编解码器中包体的内容
+// 格式：4字节序列号（大端序）+ 1字节帧类型 + 1字节方法名长度 + 方法名 + 包体
+type frame struct {
+	seq    uint32 // 序列号，用来匹配请求和响应
+	kind   byte   // 帧类型
+	method string // 方法名，只有请求帧才有
+	body   []byte // 包体
+}
+
+// encodeFrame 编码帧
+func encodeFrame(seq uint32, kind byte, method string, body []byte) []byte {
+	bytes := make([]byte, headerLen+len(method)+len(body))
+	binary.BigEndian.PutUint32(bytes, seq)
+	bytes[4] = kind
+	bytes[5] = byte(len(method))
+	copy(bytes[headerLen:], method)
+	copy(bytes[headerLen+len(method):], body)
+	return bytes
+}
+
+// decodeFrame 解码帧，返回的包体引用原字节数组
+func decodeFrame(bytes []byte) (*frame, error) {
+	if len(bytes) < headerLen {
+		return nil, ErrIllegalFrame
+	}
+	methodLen := int(bytes[5])
+	if len(bytes) < headerLen+methodLen {
+		return nil, ErrIllegalFrame
+	}
+
+	f := &frame{
+		seq:    binary.BigEndian.Uint32(bytes),
+		kind:   bytes[4],
+		method: string(bytes[headerLen : headerLen+methodLen]),
+		body:   bytes[headerLen+methodLen:],
+	}
+	if f.kind > kindError {
+		return nil, ErrIllegalFrame
+	}
+	return f, nil
+}
@@ -0,0 +1,103 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alberliu/gn"
+	"sync"
+)
+
+var log = gn.GetLogger()
+
+// HandlerFunc RPC方法处理函数，req只在调用期间有效，如需异步使用，需要制作拷贝
+type HandlerFunc func(c *gn.Conn, req []byte) ([]byte, error)
+
+// Server RPC服务端，实现了gn.Handler，需要配合gn.WithDecoder和gn.WithEncoder使用
+type Server struct {
+	lock    sync.RWMutex
+	methods map[string]HandlerFunc // 注册的方法
+	clients sync.Map               // 向客户端发起调用的RPC客户端，key为*gn.Conn
+}
+
+// NewServer 创建RPC服务端
+func NewServer() *Server {
+	return &Server{
+		methods: make(map[string]HandlerFunc),
+	}
+}
+
+// Register 注册方法，方法名长度不能超过255
+func (s *Server) Register(method string, handler HandlerFunc) {
+	if len(method) == 0 || len(method) > 255 {
+		panic("method length must between 1 and 255")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.methods[method] = handler
+}
+
+// Client 获取向该连接发起调用的RPC客户端，用于服务端主动调用客户端
+func (s *Server) Client(c *gn.Conn) *Client {
+	v, ok := s.clients.Load(c)
+	if ok {
+		return v.(*Client)
+	}
+	v, _ = s.clients.LoadOrStore(c, NewClient(c.WriteWithEncoder))
+	return v.(*Client)
+}
+
+// OnConnect 当TCP长连接建立成功是回调
+func (s *Server) OnConnect(c *gn.Conn) {}
+
+// OnMessage 处理请求帧，响应帧交给该连接对应的RPC客户端
+func (s *Server) OnMessage(c *gn.Conn, bytes []byte) {
+	f, err := decodeFrame(bytes)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if f.kind != kindRequest {
+		v, ok := s.clients.Load(c)
+		if !ok {
+			log.Error(fmt.Sprintf("unexpected response seq %d", f.seq))
+			return
+		}
+		v.(*Client).receive(f)
+		return
+	}
+
+	s.lock.RLock()
+	handler, ok := s.methods[f.method]
+	s.lock.RUnlock()
+
+	var resp []byte
+	if ok {
+		resp, err = handler(c, f.body)
+	} else {
+		err = errors.New(fmt.Sprintf("method %s not found", f.method))
+	}
+
+	if err != nil {
+		err = c.WriteWithEncoder(encodeFrame(f.seq, kindError, "", []byte(err.Error())))
+	} else {
+		err = c.WriteWithEncoder(encodeFrame(f.seq, kindResponse, "", resp))
+	}
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// OnClose 当客户端主动断开链接或者超时时回调，等待中的调用返回连接关闭的原因，没有原因时返回ErrClosed
+func (s *Server) OnClose(c *gn.Conn, err error) {
+	v, ok := s.clients.Load(c)
+	if !ok {
+		return
+	}
+	s.clients.Delete(c)
+	if err == nil {
+		err = ErrClosed
+	}
+	v.(*Client).shutdown(err)
+}
@@ -0,0 +1,138 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/alberliu/gn"
+	"github.com/alberliu/gn/codec"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// readFrames 从连接中读取n个帧
+func readFrames(t *testing.T, conn net.Conn, buffer *codec.Buffer, decoder codec.Decoder, n int) []*frame {
+	var frames []*frame
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(frames) < n {
+		_, err := buffer.ReadFromReader(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = decoder.Decode(buffer, func(bytes []byte) {
+			f, err := decodeFrame(bytes)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// 包体引用读缓存区，需要制作拷贝
+			f.body = append([]byte(nil), f.body...)
+			frames = append(frames, f)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return frames
+}
+
+func TestServer(t *testing.T) {
+	conns := make(chan *gn.Conn, 1)
+	s := NewServer()
+	s.Register("echo", func(c *gn.Conn, req []byte) ([]byte, error) {
+		return req, nil
+	})
+	s.Register("fail", func(c *gn.Conn, req []byte) ([]byte, error) {
+		return nil, errors.New("failed")
+	})
+	s.Register("hello", func(c *gn.Conn, req []byte) ([]byte, error) {
+		conns <- c
+		return nil, nil
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	server, err := gn.NewServer(address, s,
+		gn.WithDecoder(codec.NewUvarintDecoder()), gn.WithEncoder(codec.NewUvarintEncoder(1024)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Run()
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	decoder := codec.NewUvarintDecoder()
+	encoder := codec.NewUvarintEncoder(1024)
+	buffer := codec.NewBuffer(make([]byte, 1024))
+
+	// 按方法名路由，处理函数返回错误以及方法不存在时响应错误帧
+	encoder.EncodeToWriter(conn, encodeFrame(1, kindRequest, "echo", []byte("hi")))
+	encoder.EncodeToWriter(conn, encodeFrame(2, kindRequest, "fail", nil))
+	encoder.EncodeToWriter(conn, encodeFrame(3, kindRequest, "none", nil))
+	encoder.EncodeToWriter(conn, encodeFrame(4, kindRequest, "hello", nil))
+	frames := readFrames(t, conn, buffer, decoder, 4)
+	if f := frames[0]; f.seq != 1 || f.kind != kindResponse || string(f.body) != "hi" {
+		t.Fatal(f)
+	}
+	if f := frames[1]; f.seq != 2 || f.kind != kindError || string(f.body) != "failed" {
+		t.Fatal(f)
+	}
+	if f := frames[2]; f.seq != 3 || f.kind != kindError || string(f.body) != "method none not found" {
+		t.Fatal(f)
+	}
+	if f := frames[3]; f.seq != 4 || f.kind != kindResponse {
+		t.Fatal(f)
+	}
+
+	// 服务端主动调用客户端，客户端乱序响应
+	client := s.Client(<-conns)
+	results := make(chan error, 3)
+	call := func(method, want string) {
+		resp, err := client.Call(context.Background(), method, []byte(want))
+		if err == nil && string(resp) != want {
+			err = errors.New(string(resp))
+		}
+		results <- err
+	}
+	go call("a", "a")
+	go call("b", "b")
+	requests := readFrames(t, conn, buffer, decoder, 2)
+	for i := len(requests) - 1; i >= 0; i-- {
+		f := requests[i]
+		encoder.EncodeToWriter(conn, encodeFrame(f.seq, kindResponse, "", f.body))
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("call timeout")
+		}
+	}
+
+	// 连接关闭时，等待中的调用返回连接关闭的原因
+	go call("c", "c")
+	readFrames(t, conn, buffer, decoder, 1)
+	conn.Close()
+	select {
+	case err := <-results:
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("call not closed")
+	}
+}
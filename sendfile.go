@@ -0,0 +1,83 @@
+package gn
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// maxSendFileLen 每次sendfile最多发送的字节数
+const maxSendFileLen = 1 << 30
+
+// SendFile 使用sendfile(2)发送文件f中从offset开始的n个字节，数据不经过用户态，
+// socket缓冲区已满时，剩余部分放入写缓存区，等待可写事件到来时继续发送，和Write写入的数据保持顺序，
+// 写缓存区持有f的文件描述符的副本，调用之后可以立即关闭f，
+// 未发送的字节数计入写缓存区，和Write一样受高低水位以及WithWriteBufferLimit的限制
+func (c *Conn) SendFile(f *os.File, offset, n int64) (int64, error) {
+	c.writeLock.Lock()
+	if c.isClosing() {
+		c.writeLock.Unlock()
+		return 0, ErrConnClosed
+	}
+
+	src := int(f.Fd())
+	seg := segment{file: src, offset: offset, remain: n}
+	// 写缓存区中有数据时，需要排在后面，保证顺序
+	if len(c.outbound) == 0 {
+		err := c.sendFile(&seg)
+		if err != syscall.EAGAIN {
+			c.writeLock.Unlock()
+			return n - seg.remain, err
+		}
+	}
+
+	file, err := syscall.Dup(src)
+	if err != nil {
+		c.writeLock.Unlock()
+		return n - seg.remain, err
+	}
+	syscall.CloseOnExec(file)
+	seg.file = file
+	c.push(seg)
+	overflow, changed := c.checkOutbound()
+	c.writeLock.Unlock()
+
+	err = c.afterEnqueue(overflow, changed)
+	if err != nil {
+		return n - seg.remain, err
+	}
+	return n, nil
+}
+
+// sendFile 发送文件片段，socket缓冲区已满时返回EAGAIN
+func (c *Conn) sendFile(seg *segment) error {
+	for seg.remain > 0 {
+		l := seg.remain
+		if l > maxSendFileLen {
+			l = maxSendFileLen
+		}
+		n, err := sendFile(int(c.fd), seg.file, &seg.offset, int(l))
+		if err != nil {
+			return err
+		}
+		// 文件长度不足
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		seg.remain -= int64(n)
+	}
+	return nil
+}
+
+// flushFile 发送写缓存区头部的文件片段，发送完成之后关闭文件描述符的副本
+func (c *Conn) flushFile(seg *segment) error {
+	remain := seg.remain
+	err := c.sendFile(seg)
+	c.outboundLen -= int(remain - seg.remain)
+	if err != nil {
+		return err
+	}
+	_ = syscall.Close(seg.file)
+	c.pop()
+	return nil
+}
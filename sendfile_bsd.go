@@ -0,0 +1,29 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gn
+
+import (
+	"syscall"
+)
+
+// sendFileBufferLen 使用pread代替sendfile时，每次读取的字节数
+const sendFileBufferLen = 32 * 1024
+
+// sendFile 使用pread读取src中从offset开始的数据再写入dst，并且更新offset，各个平台的sendfile(2)语义不一致
+func sendFile(dst, src int, offset *int64, n int) (int, error) {
+	if n > sendFileBufferLen {
+		n = sendFileBufferLen
+	}
+	buf := make([]byte, n)
+	n, err := syscall.Pread(src, buf, *offset)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	n, err = syscall.Write(dst, buf[:n])
+	if err != nil {
+		return 0, err
+	}
+	*offset += int64(n)
+	return n, nil
+}
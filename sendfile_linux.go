@@ -0,0 +1,10 @@
+package gn
+
+import (
+	"syscall"
+)
+
+// sendFile 使用sendfile(2)将src中从offset开始的n个字节写入dst，并且更新offset
+func sendFile(dst, src int, offset *int64, n int) (int, error) {
+	return syscall.Sendfile(dst, src, offset, n)
+}
@@ -16,7 +16,7 @@ var (
 // Handler Server 注册接口
 type Handler interface {
 	OnConnect(c *Conn)               // OnConnect 当TCP长连接建立成功是回调
-	OnMessage(c *Conn, bytes []byte) // OnMessage 当客户端有数据写入是回调
+	OnMessage(c *Conn, bytes []byte) // OnMessage 当客户端有数据写入是回调，bytes借用读缓存区，返回之后可能被覆盖，异步使用时需要复制或者调用c.GetBuffer().Retain()
 	OnClose(c *Conn, err error)      // OnClose 当客户端主动断开链接或者超时时回调,err返回关闭的原因
 }
 
@@ -24,6 +24,8 @@ const (
 	EventIn      = 1 // 数据流入
 	EventClose   = 2 // 断开连接
 	EventTimeout = 3 // 检测到超时
+	EventOut     = 4 // 可以写入
+	EventHandoff = 5 // 将连接移交给新进程
 )
 
 type event struct {
@@ -33,21 +35,41 @@ type event struct {
 
 // Server TCP服务
 type Server struct {
-	netpoll        netpoll      // 具体操作系统网络实现
-	options        *options     // 服务参数
-	readBufferPool *sync.Pool   // 读缓存区内存池
-	handler        Handler      // 注册的处理
-	ioEventQueues  []chan event // IO事件队列集合
-	ioQueueNum     int32        // IO事件队列集合数量
-	conns          sync.Map     // TCP长连接管理
-	connsNum       int64        // 当前建立的长连接数量
-	stop           chan int     // 服务器关闭信号
-}
-
-// NewServer 创建server服务器
+	netpoll        netpoll            // 具体操作系统网络实现
+	sender         asyncSender        // 异步发送数据的netpoll实现，不支持时为nil
+	options        *options           // 服务参数
+	readBufferPool *sync.Pool         // 读缓存区内存池
+	handler        Handler            // 注册的处理
+	writability    WritabilityHandler // 可写状态变化的回调，handler没有实现时为nil
+	ioQueues       []*ioQueue         // IO事件队列集合
+	ioQueueNum     int32              // IO事件队列集合数量
+	conns          sync.Map           // TCP长连接管理
+	connsNum       int64              // 当前建立的长连接数量
+	loopConns      []int64            // 每个IO goroutine当前的连接数
+	nextLoop       uint32             // 轮询策略下一个IO goroutine
+	limiter        *rateLimiter       // 所有连接共享的限流器
+	stop           chan int           // 服务器关闭信号
+	stopAccept     chan struct{}      // 停止接收新连接的信号
+	stopAcceptOnce sync.Once          // 保证stopAccept只关闭一次
+	acceptWG       sync.WaitGroup     // 等待accept goroutine退出
+	handoff        atomic.Value       // 正在进行的连接移交，类型为*handoff
+}
+
+// NewServer 创建server服务器，可以通过WithAddresses同时监听多个地址
 func NewServer(address string, handler Handler, opts ...Option) (*Server, error) {
 	options := getOptions(opts...)
 
+	// 监听address以及WithAddresses设置的地址
+	listenFDs, err := listenAll(append([]string{address}, options.addresses...), options.activationNames)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return newServer(listenFDs, handler, options)
+}
+
+// newServer 使用监听的文件描述符创建server服务器，创建失败时关闭监听的文件描述符
+func newServer(listenFDs []int, handler Handler, options *options) (*Server, error) {
 	// 初始化读缓存区内存池
 	readBufferPool := &sync.Pool{
 		New: func() interface{} {
@@ -57,28 +79,38 @@ func NewServer(address string, handler Handler, opts ...Option) (*Server, error)
 	}
 
 	// 初始化epoll网络
-	netpoll, err := newNetpoll(address)
+	netpoll, err := newNetpoll(listenFDs, options)
 	if err != nil {
 		log.Error(err)
+		closeAll(listenFDs)
 		return nil, err
 	}
 
 	// 初始化io事件队列
-	ioEventQueues := make([]chan event, options.ioGNum)
-	for i := range ioEventQueues {
-		ioEventQueues[i] = make(chan event, options.ioEventQueueLen)
+	ioQueues := make([]*ioQueue, options.ioGNum)
+	for i := range ioQueues {
+		ioQueues[i] = newIOQueue(options.ioEventQueueLen)
 	}
 
+	// 支持异步发送时，写缓存区中的数据通过netpoll批量提交发送
+	sender, _ := netpoll.(asyncSender)
+
+	wrapped := chainMiddlewares(handler, options.middlewares)
 	return &Server{
 		netpoll:        netpoll,
+		sender:         sender,
 		options:        options,
 		readBufferPool: readBufferPool,
-		handler:        handler,
-		ioEventQueues:  ioEventQueues,
+		handler:        wrapped,
+		writability:    getWritabilityHandler(wrapped, handler),
+		ioQueues:       ioQueues,
 		ioQueueNum:     int32(options.ioGNum),
 		conns:          sync.Map{},
 		connsNum:       0,
+		loopConns:      make([]int64, options.ioGNum),
+		limiter:        newRateLimiter(options.globalRateLimit),
 		stop:           make(chan int),
+		stopAccept:     make(chan struct{}),
 	}, nil
 }
 
@@ -94,6 +126,9 @@ func (s *Server) GetConn(fd int32) (*Conn, bool) {
 // Run 启动服务
 func (s *Server) Run() {
 	log.Info("gn server run")
+	if fd, ok := inheritedHandoff(); ok {
+		go s.receiveConns(fd)
+	}
 	s.startAccept()
 	s.startIOConsumer()
 	s.startIOProducer()
@@ -107,32 +142,49 @@ func (s *Server) GetConnsNum() int64 {
 // Stop 启动服务
 func (s *Server) Stop() {
 	close(s.stop)
-	for _, queue := range s.ioEventQueues {
-		close(queue)
+	s.acceptWG.Wait()
+	s.netpoll.stopAccept()
+	for _, queue := range s.ioQueues {
+		queue.close()
 	}
 }
 
-// handleEvent 处理事件
+// handleEvent 处理事件，可以在任意goroutine中调用
 func (s *Server) handleEvent(event event) {
-	index := event.FD % s.ioQueueNum
-	s.ioEventQueues[index] <- event
+	s.ioQueues[s.loopOf(event.FD)].push(event)
+}
+
+// collectEvent 生产者在一次wait中按照队列收集事件，wait返回之后每个队列只写入一次
+func (s *Server) collectEvent(event event) {
+	q := s.ioQueues[s.loopOf(event.FD)]
+	q.local = append(q.local, event)
+}
+
+// loopOf 获取处理文件描述符对应连接的IO goroutine
+func (s *Server) loopOf(fd int32) int32 {
+	if v, ok := s.conns.Load(fd); ok {
+		return v.(*Conn).loop
+	}
+	return fd % s.ioQueueNum
 }
 
 // StartProducer 启动生产者
 func (s *Server) startIOProducer() {
 	log.Info("start io producer")
+	s.lockThread(s.options.cpus)
+	handle := s.collectEvent
 	for {
 		select {
 		case <-s.stop:
 			log.Error("stop producer")
 			return
 		default:
-			events, err := s.netpoll.getEvents()
+			err := s.netpoll.wait(handle)
 			if err != nil {
 				log.Error(err)
 			}
-			for i := range events {
-				s.handleEvent(events[i])
+			for _, queue := range s.ioQueues {
+				queue.flushLocal()
 			}
 		}
 	}
@@ -140,6 +192,7 @@ func (s *Server) startIOProducer() {
 
 // startAccept 开始接收连接请求
 func (s *Server) startAccept() {
+	s.acceptWG.Add(s.options.acceptGNum)
 	for i := 0; i < s.options.acceptGNum; i++ {
 		go s.accept()
 	}
@@ -148,66 +201,137 @@ func (s *Server) startAccept() {
 
 // accept 接收连接请求
 func (s *Server) accept() {
+	defer s.acceptWG.Done()
 	for {
 		select {
 		case <-s.stop:
 			return
+		case <-s.stopAccept:
+			return
 		default:
 			nfd, addr, err := s.netpoll.accept()
 			if err != nil {
+				// 没有新连接，重新检查是否需要停止接收连接
+				if err == syscall.EAGAIN {
+					continue
+				}
 				log.Error(err)
 				continue
 			}
 
-			fd := int32(nfd)
-			conn := newConn(fd, addr, s)
-			s.conns.Store(fd, conn)
-			atomic.AddInt64(&s.connsNum, 1)
-			s.handler.OnConnect(conn)
+			err = s.addConn(nfd, addr, s.options.proxyProtocol)
+			if err != nil {
+				log.Error(err)
+			}
 		}
 	}
 }
 
+// addConn 添加已经建立的连接，先放入conns再监听读事件，监听之后产生的事件都能找到连接，
+// proxy为true时，解析完PROXY protocol头部之后才回调OnConnect
+func (s *Server) addConn(nfd int, addr string, proxy bool) error {
+	err := syscall.SetNonblock(nfd, true)
+	if err != nil {
+		_ = syscall.Close(nfd)
+		return err
+	}
+
+	fd := int32(nfd)
+	conn := newConn(fd, addr, s)
+	conn.proxyPending = proxy
+	s.conns.Store(fd, conn)
+	atomic.AddInt64(&s.connsNum, 1)
+	if !proxy {
+		conn.connect()
+	}
+
+	err = conn.register()
+	if err != nil {
+		conn.CloseWithError(err)
+		return err
+	}
+	return nil
+}
+
 // StartConsumer 启动消费者
 func (s *Server) startIOConsumer() {
-	for _, queue := range s.ioEventQueues {
-		go s.consumeIOEvent(queue)
+	for i, queue := range s.ioQueues {
+		go s.consumeIOEvent(queue, int32(i), s.loopCPUs(i))
 	}
-	log.Info(fmt.Sprintf("start io event consumer by %d goroutine", len(s.ioEventQueues)))
+	log.Info(fmt.Sprintf("start io event consumer by %d goroutine", len(s.ioQueues)))
 }
 
-// ConsumeIO 消费IO事件
-func (s *Server) consumeIOEvent(queue chan event) {
-	for event := range queue {
-		v, ok := s.conns.Load(event.FD)
+// ConsumeIO 消费IO事件，每次取出队列中所有待处理的事件
+func (s *Server) consumeIOEvent(queue *ioQueue, loop int32, cpus []int) {
+	s.lockThread(cpus)
+	var buf []event
+	for {
+		events, ok := queue.pop(buf)
 		if !ok {
-			log.Error("not found in conns,", event.FD)
-			continue
+			return
+		}
+		// 处理一批事件期间产生的io_uring请求（发送、重新接收）在处理完成之后一次提交
+		s.netpoll.batchStart()
+		for i := range events {
+			s.handleIOEvent(events[i], loop)
 		}
-		c := v.(*Conn)
+		s.netpoll.batchEnd()
+		buf = events
+	}
+}
 
-		if event.Type == EventClose {
-			c.Close()
-			s.handler.OnClose(c, io.EOF)
-			continue
+// handleIOEvent 处理IO事件，loop是当前IO goroutine的序号
+func (s *Server) handleIOEvent(event event, loop int32) {
+	v, ok := s.conns.Load(event.FD)
+	if !ok {
+		if event.Type == EventHandoff {
+			s.handleHandoff(nil)
+			return
 		}
-		if event.Type == EventTimeout {
-			c.Close()
-			s.handler.OnClose(c, ErrReadTimeout)
-			continue
+		log.Error("not found in conns,", event.FD)
+		return
+	}
+	c := v.(*Conn)
+	// 连接先放入conns再注册到netpoll，之后的事件都会分发到c.loop，
+	// 分发到其他IO goroutine的事件属于复用该文件描述符之前的连接，丢弃，防止两个IO goroutine同时处理同一个连接
+	if c.loop != loop {
+		if event.Type == EventHandoff {
+			s.handleHandoff(nil)
 		}
+		return
+	}
 
-		err := c.read()
-		if err != nil {
-			// 服务端关闭连接
-			if err == syscall.EBADF {
-				continue
-			}
-			c.Close()
-			s.handler.OnClose(c, err)
+	if event.Type == EventHandoff {
+		s.handleHandoff(c)
+		return
+	}
 
+	if event.Type == EventClose {
+		c.CloseWithError(io.EOF)
+		return
+	}
+	if event.Type == EventTimeout {
+		c.CloseWithError(ErrReadTimeout)
+		return
+	}
+	if event.Type == EventOut {
+		err := c.flush()
+		if err != nil {
+			c.CloseWithError(err)
 			log.Debug(err)
 		}
+		return
+	}
+
+	err := c.read()
+	if err != nil {
+		// 服务端关闭连接
+		if err == syscall.EBADF {
+			return
+		}
+		c.CloseWithError(err)
+
+		log.Debug(err)
 	}
 }
 
@@ -0,0 +1,1029 @@
+package gn
+
+import (
+	"bufio"
+	"github.com/alberliu/gn/codec"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startTestServer 在随机端口启动服务，返回服务地址
+func startTestServer(t *testing.T, handler Handler, opts ...Option) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	server, err := NewServer(address, handler, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Run()
+	return server, address
+}
+
+type panicHandler struct {
+	closed chan error
+}
+
+func (*panicHandler) OnConnect(c *Conn) {}
+
+func (*panicHandler) OnMessage(c *Conn, bytes []byte) {
+	if string(bytes) == "panic" {
+		panic("boom")
+	}
+	c.Write(bytes)
+}
+
+func (h *panicHandler) OnClose(c *Conn, err error) {
+	h.closed <- err
+}
+
+func TestRecovery(t *testing.T) {
+	handler := &panicHandler{closed: make(chan error, 1)}
+	_, address := startTestServer(t, handler, WithMiddleware(Recovery()), WithIOGNum(1))
+
+	conn1, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Close()
+	conn2, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+
+	conn1.Write([]byte("panic"))
+	select {
+	case err := <-handler.closed:
+		if err != ErrHandlerPanic {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("conn not closed")
+	}
+
+	// 同一个IO goroutine上的其他连接不受影响
+	conn2.Write([]byte("hello"))
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 5)
+	_, err = conn2.Read(buf)
+	if err != nil || string(buf) != "hello" {
+		t.Fatal(string(buf), err)
+	}
+}
+
+type negotiateHandler struct{}
+
+func (*negotiateHandler) OnConnect(c *Conn) {}
+
+// OnMessage 第一个字节为协议版本，之后切换为uvarint编解码
+func (*negotiateHandler) OnMessage(c *Conn, bytes []byte) {
+	if c.GetData() == nil {
+		c.SetData(string(bytes))
+		c.SetDecoder(codec.NewUvarintDecoder())
+		c.SetEncoder(codec.NewUvarintEncoder(1024))
+		return
+	}
+	c.WriteWithEncoder(append([]byte(c.GetData().(string)), bytes...))
+}
+
+func (*negotiateHandler) OnClose(c *Conn, err error) {}
+
+func TestSetDecoder(t *testing.T) {
+	_, address := startTestServer(t, &negotiateHandler{}, WithCodecFactory(func(c *Conn) (codec.Decoder, codec.Encoder) {
+		return codec.NewFixedLenDecoder(1), nil
+	}))
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 版本协商帧和之后的包在同一次写入中到达
+	conn.Write([]byte("2\x05hello"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 7)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "\x062hello" {
+		t.Fatal(string(buf), err)
+	}
+}
+
+type proxyHandler struct {
+	addrs chan string
+}
+
+func (h *proxyHandler) OnConnect(c *Conn) {
+	h.addrs <- c.GetAddr()
+}
+
+func (*proxyHandler) OnMessage(c *Conn, bytes []byte) {
+	c.Write(bytes)
+}
+
+func (h *proxyHandler) OnClose(c *Conn, err error) {}
+
+func TestProxyProtocol(t *testing.T) {
+	handler := &proxyHandler{addrs: make(chan string, 2)}
+	_, address := startTestServer(t, handler, WithProxyProtocol(true))
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 2222\r\nhello"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "hello" {
+		t.Fatal(string(buf), err)
+	}
+	if addr := <-handler.addrs; addr != "1.2.3.4:1111" {
+		t.Fatal(addr)
+	}
+
+	// 严格模式下没有头部的连接被关闭，不会回调OnConnect
+	conn2, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	conn2.Write([]byte("hello\r\n"))
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn2.Read(buf)
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	if len(handler.addrs) != 0 {
+		t.Fatal(<-handler.addrs)
+	}
+}
+
+type echoHandler struct {
+	closed chan error
+}
+
+func (*echoHandler) OnConnect(c *Conn) {}
+
+func (*echoHandler) OnMessage(c *Conn, bytes []byte) {
+	c.Write(bytes)
+}
+
+func (h *echoHandler) OnClose(c *Conn, err error) {
+	if h.closed != nil {
+		h.closed <- err
+	}
+}
+
+func TestRateLimitClose(t *testing.T) {
+	handler := &echoHandler{closed: make(chan error, 1)}
+	_, address := startTestServer(t, handler,
+		WithDecoder(codec.NewFixedLenDecoder(1)),
+		WithConnRateLimit(RateLimit{MessagesPerSecond: 2}),
+		WithRateLimitPolicy(RateLimitClose))
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("abc"))
+
+	select {
+	case err := <-handler.closed:
+		if err != ErrRateLimited {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("conn not closed")
+	}
+}
+
+func TestRateLimitOversize(t *testing.T) {
+	_, address := startTestServer(t, &echoHandler{},
+		WithDecoder(codec.NewFixedLenDecoder(20)),
+		WithConnRateLimit(RateLimit{BytesPerSecond: 10, BytesBurst: 10}),
+		WithRateLimitPolicy(RateLimitDrop))
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 超过BytesBurst的包在桶满时通过，之后透支，第二个包被丢弃
+	conn.Write(make([]byte, 40))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(conn, make([]byte, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	n, _ := conn.Read(make([]byte, 20))
+	if n != 0 {
+		t.Fatal(n)
+	}
+}
+
+func TestRateLimitPause(t *testing.T) {
+	testRateLimitPause(t)
+}
+
+func testRateLimitPause(t *testing.T, opts ...Option) {
+	_, address := startTestServer(t, &echoHandler{}, append(opts,
+		WithReadBufferLen(100),
+		WithConnRateLimit(RateLimit{BytesPerSecond: 1000, BytesBurst: 100}))...)
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 每次最多读取100字节，令牌透支之后暂停读取，500字节至少需要等待300毫秒
+	start := time.Now()
+	conn.Write(make([]byte, 500))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.ReadFull(conn, make([]byte, 500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatal(elapsed)
+	}
+}
+
+type floodHandler struct {
+	size     int
+	file     *os.File // 不为nil时使用SendFile发送文件
+	writable chan bool
+	closed   chan error
+}
+
+func (h *floodHandler) OnConnect(c *Conn) {
+	if h.file != nil {
+		c.SendFile(h.file, 0, int64(h.size))
+		return
+	}
+	bytes := make([]byte, h.size)
+	for i := range bytes {
+		bytes[i] = byte(i)
+	}
+	c.Write(bytes)
+}
+
+func (*floodHandler) OnMessage(c *Conn, bytes []byte) {}
+
+func (h *floodHandler) OnClose(c *Conn, err error) {
+	h.closed <- err
+}
+
+func (h *floodHandler) OnWritabilityChanged(c *Conn, writable bool) {
+	if writable != c.IsWritable() {
+		panic("writable mismatch")
+	}
+	h.writable <- writable
+}
+
+func TestWriteBufferWatermark(t *testing.T) {
+	handler := &floodHandler{size: 8 << 20, writable: make(chan bool, 2), closed: make(chan error, 1)}
+	_, address := startTestServer(t, handler, WithMiddleware(Recovery()))
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 客户端不读取，写缓存区超过高水位
+	select {
+	case writable := <-handler.writable:
+		if writable {
+			t.Fatal(writable)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("writability not changed")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	bytes := make([]byte, handler.size)
+	_, err = io.ReadFull(conn, bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range bytes {
+		if bytes[i] != byte(i) {
+			t.Fatal(i)
+		}
+	}
+	if writable := <-handler.writable; !writable {
+		t.Fatal(writable)
+	}
+}
+
+func TestWriteBufferLimit(t *testing.T) {
+	handler := &floodHandler{size: 8 << 20, writable: make(chan bool, 2), closed: make(chan error, 1)}
+	_, address := startTestServer(t, handler, WithWriteBufferLimit(1<<20))
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case err := <-handler.closed:
+		if err != ErrWriteBufferOverflow {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("conn not closed")
+	}
+}
+
+func TestSendFileWriteBufferLimit(t *testing.T) {
+	f, err := ioutil.TempFile("", "gn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	f.Write(make([]byte, 8<<20))
+
+	// 文件中未发送的部分同样计入写缓存区
+	handler := &floodHandler{size: 8 << 20, file: f, writable: make(chan bool, 2), closed: make(chan error, 1)}
+	_, address := startTestServer(t, handler, WithWriteBufferLimit(1<<20))
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case err := <-handler.closed:
+		if err != ErrWriteBufferOverflow {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("conn not closed")
+	}
+}
+
+type writevHandler struct{}
+
+func (*writevHandler) OnConnect(c *Conn) {}
+
+func (*writevHandler) OnMessage(c *Conn, bytes []byte) {
+	buffers := make([][]byte, 0, 2000)
+	for i := 0; i < 2000; i++ {
+		buffers = append(buffers, bytes)
+	}
+	c.Writev(buffers)
+}
+
+func (*writevHandler) OnClose(c *Conn, err error) {}
+
+func TestWritev(t *testing.T) {
+	_, address := startTestServer(t, &writevHandler{})
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 超过IOV_MAX的字节数组分多次writev写入
+	conn.Write([]byte("abc"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	bytes := make([]byte, 6000)
+	_, err = io.ReadFull(conn, bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(bytes); i += 3 {
+		if string(bytes[i:i+3]) != "abc" {
+			t.Fatal(i, string(bytes[i:i+3]))
+		}
+	}
+}
+
+type sendFileHandler struct {
+	file *os.File
+}
+
+func (h *sendFileHandler) OnConnect(c *Conn) {
+	c.Write([]byte("head"))
+	c.SendFile(h.file, 1, 4<<20)
+	c.Write([]byte("tail"))
+}
+
+func (*sendFileHandler) OnMessage(c *Conn, bytes []byte) {}
+
+func (*sendFileHandler) OnClose(c *Conn, err error) {}
+
+func TestSendFile(t *testing.T) {
+	content := make([]byte, 4<<20+1)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	f, err := ioutil.TempFile("", "gn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	f.Write(content)
+
+	_, address := startTestServer(t, &sendFileHandler{file: f})
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	bytes := make([]byte, 4<<20+8)
+	_, err = io.ReadFull(conn, bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bytes[:4]) != "head" || string(bytes[len(bytes)-4:]) != "tail" {
+		t.Fatal(string(bytes[:4]), string(bytes[len(bytes)-4:]))
+	}
+	for i := 4; i < len(bytes)-4; i++ {
+		if bytes[i] != content[i-3] {
+			t.Fatal(i)
+		}
+	}
+}
+
+type spliceHandler struct {
+	conns chan *Conn
+}
+
+func (h *spliceHandler) OnConnect(c *Conn) {
+	select {
+	case h.conns <- c:
+	default:
+		peer := <-h.conns
+		Splice(peer, c)
+		Splice(c, peer)
+		// 通知客户端转发已经建立
+		peer.Write([]byte("."))
+		c.Write([]byte("."))
+	}
+}
+
+func (*spliceHandler) OnMessage(c *Conn, bytes []byte) {}
+
+func (*spliceHandler) OnClose(c *Conn, err error) {}
+
+func TestSplice(t *testing.T) {
+	testSplice(t)
+}
+
+func testSplice(t *testing.T, opts ...Option) {
+	_, address := startTestServer(t, &spliceHandler{conns: make(chan *Conn, 1)}, append(opts, WithAcceptGNum(1))...)
+
+	conn1, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Close()
+	time.Sleep(50 * time.Millisecond)
+	conn2, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+
+	bytes := make([]byte, 4<<20)
+	for _, conn := range []net.Conn{conn1, conn2} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = io.ReadFull(conn, bytes[:1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content := make([]byte, len(bytes))
+	for i := range content {
+		content[i] = byte(i)
+	}
+	go conn1.Write(content)
+	conn2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn2, bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range bytes {
+		if bytes[i] != content[i] {
+			t.Fatal(i)
+		}
+	}
+
+	conn2.Write([]byte("pong"))
+	conn1.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(conn1, bytes[:4])
+	if err != nil || string(bytes[:4]) != "pong" {
+		t.Fatal(err, string(bytes[:4]))
+	}
+}
+
+type pingHandler struct {
+	pings chan struct{}
+}
+
+func (*pingHandler) OnConnect(c *Conn) {}
+
+func (h *pingHandler) OnMessage(c *Conn, bytes []byte) {
+	if string(bytes) == "ping" {
+		h.pings <- struct{}{}
+		return
+	}
+	// 处理速度慢于发送速度，读取不到EAGAIN
+	time.Sleep(time.Millisecond)
+}
+
+func (*pingHandler) OnClose(c *Conn, err error) {}
+
+func TestReadBudget(t *testing.T) {
+	handler := &pingHandler{pings: make(chan struct{}, 1)}
+	_, address := startTestServer(t, handler, WithIOGNum(1), WithReadBudget(0, 16))
+
+	flood, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flood.Close()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 持续发送数据的连接不会占用唯一的IO goroutine
+	go func() {
+		bytes := make([]byte, 64*1024)
+		for {
+			_, err := flood.Write(bytes)
+			if err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	conn.Write([]byte("ping"))
+
+	select {
+	case <-handler.pings:
+	case <-time.After(time.Second):
+		t.Fatal("conn starved")
+	}
+}
+
+func TestLoadBalance(t *testing.T) {
+	handler := &echoHandler{closed: make(chan error, 4)}
+	server, address := startTestServer(t, handler, WithIOGNum(2), WithLoadBalance(LoadBalanceRoundRobin))
+
+	var conns []net.Conn
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("a"))
+		conn.Read(make([]byte, 1))
+		conns = append(conns, conn)
+	}
+	if nums := server.GetLoopConnsNum(); nums[0] != 2 || nums[1] != 2 {
+		t.Fatal(nums)
+	}
+
+	for _, conn := range conns {
+		conn.Close()
+		<-handler.closed
+	}
+	if nums := server.GetLoopConnsNum(); nums[0] != 0 || nums[1] != 0 {
+		t.Fatal(nums)
+	}
+}
+
+type upgradeHandler struct {
+	name string
+}
+
+func (*upgradeHandler) OnConnect(c *Conn) {}
+
+func (h *upgradeHandler) OnMessage(c *Conn, bytes []byte) {
+	c.Write([]byte(h.name))
+}
+
+func (*upgradeHandler) OnClose(c *Conn, err error) {}
+
+func TestUpgrade(t *testing.T) {
+	old, address := startTestServer(t, &upgradeHandler{name: "o"})
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	request := func(conn net.Conn, want string) {
+		conn.Write([]byte("a"))
+		bytes := make([]byte, 1)
+		_, err := io.ReadFull(conn, bytes)
+		if err != nil || string(bytes) != want {
+			t.Fatal(string(bytes), err)
+		}
+	}
+	request(conn, "o")
+
+	// 新进程继承监听的文件描述符，忽略address
+	listenFD, err := syscall.Dup(old.netpoll.listeners()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(EnvListenFD, strconv.Itoa(listenFD))
+	upgraded, _ := startTestServer(t, &upgradeHandler{name: "n"})
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go upgraded.receiveConns(fds[1])
+	old.handoffConns(fds[0])
+	syscall.Close(fds[0])
+
+	// 已建立的连接由新进程处理
+	request(conn, "n")
+	if err := old.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// 旧进程停止接收连接之后，新连接由新进程接收
+	conn2, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	request(conn2, "n")
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	server, address := startTestServer(t, &echoHandler{})
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("a"))
+	conn.Read(make([]byte, 1))
+
+	if err := server.Shutdown(50 * time.Millisecond); err != ErrShutdownTimeout {
+		t.Fatal(err)
+	}
+	_, err = conn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+}
+
+type localAddrHandler struct{}
+
+func (*localAddrHandler) OnConnect(c *Conn) {}
+
+func (*localAddrHandler) OnMessage(c *Conn, bytes []byte) {
+	c.Write([]byte(c.LocalAddr() + "\n"))
+}
+
+func (*localAddrHandler) OnClose(c *Conn, err error) {}
+
+func TestAddresses(t *testing.T) {
+	freeAddress := func(network, address string) string {
+		l, err := net.Listen(network, address)
+		if err != nil {
+			t.Skip(err)
+		}
+		defer l.Close()
+		return l.Addr().String()
+	}
+	ipv6 := freeAddress("tcp6", "[::1]:0")
+	_, ipv4 := startTestServer(t, &localAddrHandler{}, WithAddresses(ipv6))
+
+	for _, address := range []string{ipv4, ipv6} {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("a"))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil || line != address+"\n" {
+			t.Fatal(address, line, err)
+		}
+	}
+}
+
+func TestNewServerFromListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServerFromListener(l, &echoHandler{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Run()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("a"))
+	bytes := make([]byte, 1)
+	_, err = io.ReadFull(conn, bytes)
+	if err != nil || string(bytes) != "a" {
+		t.Fatal(string(bytes), err)
+	}
+}
+
+func TestAddConn(t *testing.T) {
+	server, _ := startTestServer(t, &echoHandler{})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 连接交给Server之前写入的数据同样能被读取
+	conn.Write([]byte("a"))
+
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.AddConn(accepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("b"))
+
+	bytes := make([]byte, 2)
+	_, err = io.ReadFull(conn, bytes)
+	if err != nil || string(bytes) != "ab" {
+		t.Fatal(string(bytes), err)
+	}
+	if server.GetConnsNum() != 1 {
+		t.Fatal(server.GetConnsNum())
+	}
+}
+
+type rejectHandler struct{}
+
+func (*rejectHandler) OnConnect(c *Conn) {
+	c.Close()
+}
+
+func (*rejectHandler) OnMessage(c *Conn, bytes []byte) {}
+
+func (*rejectHandler) OnClose(c *Conn, err error) {}
+
+func TestCloseOnConnect(t *testing.T) {
+	server, address := startTestServer(t, &rejectHandler{})
+
+	// OnConnect中关闭的连接不会再注册到netpoll
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.AddConn(accepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn2, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+
+	for _, c := range []net.Conn{conn, conn2} {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = c.Read(make([]byte, 1))
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+	if server.GetConnsNum() != 0 {
+		t.Fatal(server.GetConnsNum())
+	}
+}
+
+type emptyWriteHandler struct{}
+
+func (*emptyWriteHandler) OnConnect(c *Conn) {
+	c.Write(make([]byte, 8<<20))
+	c.Write(nil)
+	c.Writev([][]byte{{}, nil})
+}
+
+func (*emptyWriteHandler) OnMessage(c *Conn, bytes []byte) {
+	c.Write(bytes)
+}
+
+func (*emptyWriteHandler) OnClose(c *Conn, err error) {}
+
+func TestEmptyWrite(t *testing.T) {
+	_, address := startTestServer(t, &emptyWriteHandler{})
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// 写缓存区中的数据写完之后，空的写入不会阻塞后续的写入
+	_, err = io.ReadFull(conn, make([]byte, 8<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("a"))
+	bytes := make([]byte, 1)
+	_, err = io.ReadFull(conn, bytes)
+	if err != nil || string(bytes) != "a" {
+		t.Fatal(string(bytes), err)
+	}
+}
+
+func TestStaleEvent(t *testing.T) {
+	handler := &echoHandler{closed: make(chan error, 1)}
+	server, address := startTestServer(t, handler, WithIOGNum(2),
+		WithLoadBalanceFunc(func(c *Conn, loopConns []int64) int { return 1 }))
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("a"))
+	conn.Read(make([]byte, 1))
+
+	var c *Conn
+	server.conns.Range(func(key, value interface{}) bool {
+		c = value.(*Conn)
+		return false
+	})
+	// 复用文件描述符之前分发到其他IO goroutine的事件被丢弃
+	server.handleIOEvent(event{FD: c.GetFd(), Type: EventClose}, 0)
+	if c.isClosed() {
+		t.Fatal("closed by stale event")
+	}
+	server.handleIOEvent(event{FD: c.GetFd(), Type: EventClose}, 1)
+	if !c.isClosed() {
+		t.Fatal("not closed")
+	}
+}
+
+// acceptPair 返回一对已经建立的连接，accepted用于交给Server
+func acceptPair(t *testing.T, l net.Listener) (conn net.Conn, accepted net.Conn) {
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, accepted
+}
+
+func TestClosedConnInterest(t *testing.T) {
+	server, _ := startTestServer(t, &echoHandler{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn1, accepted1 := acceptPair(t, l)
+	defer conn1.Close()
+	conn2, accepted2 := acceptPair(t, l)
+	defer conn2.Close()
+
+	addConn := func(fd int) *Conn {
+		err := server.AddConnFD(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var c *Conn
+		server.conns.Range(func(key, value interface{}) bool {
+			c = value.(*Conn)
+			return false
+		})
+		return c
+	}
+	fd1, err := detachFD(accepted1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted1.Close()
+	fd2, err := detachFD(accepted2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted2.Close()
+
+	c1 := addConn(fd1)
+	c1.Close()
+	// 复用已经关闭的连接的文件描述符
+	nfd, err := unix.FcntlInt(uintptr(fd2), unix.F_DUPFD_CLOEXEC, fd1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(fd2)
+	c2 := addConn(nfd)
+	if c1.GetFd() != c2.GetFd() {
+		t.Skip("fd not reused")
+	}
+
+	// 已经关闭的连接修改监听事件时，不会修改复用该文件描述符的新连接
+	c2.setReadInterest(false)
+	c1.setReadInterest(true)
+	c1.setWriteInterest(true)
+	// dst的IO goroutine恢复已经关闭的src的读取
+	(&splicer{src: c1}).resume()
+	conn2.Write([]byte("a"))
+	conn2.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	n, _ := conn2.Read(make([]byte, 1))
+	if n != 0 {
+		t.Fatal("read interest modified by closed conn")
+	}
+
+	c2.setReadInterest(true)
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	bytes := make([]byte, 1)
+	_, err = io.ReadFull(conn2, bytes)
+	if err != nil || string(bytes) != "a" {
+		t.Fatal(string(bytes), err)
+	}
+}
+
+type closeAfterFlushHandler struct{}
+
+func (*closeAfterFlushHandler) OnConnect(c *Conn) {
+	c.Write(make([]byte, 8<<20))
+	c.CloseAfterFlush()
+	if _, err := c.Write([]byte("a")); err != ErrConnClosed {
+		panic(err)
+	}
+}
+
+func (*closeAfterFlushHandler) OnMessage(c *Conn, bytes []byte) {}
+
+func (*closeAfterFlushHandler) OnClose(c *Conn, err error) {}
+
+func TestCloseAfterFlush(t *testing.T) {
+	_, address := startTestServer(t, &closeAfterFlushHandler{})
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// 写缓存区中的数据全部写入之后才关闭连接
+	bytes, err := ioutil.ReadAll(conn)
+	if err != nil || len(bytes) != 8<<20 {
+		t.Fatal(len(bytes), err)
+	}
+}
@@ -0,0 +1,201 @@
+package gn
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"syscall"
+)
+
+var (
+	ErrSpliceNotSupported = errors.New("splice not supported")
+	ErrAlreadySpliced     = errors.New("conn already spliced")
+)
+
+// spliceChunkLen 每次从socket移入管道的最大字节数，不超过管道的默认容量
+const spliceChunkLen = 64 * 1024
+
+// splicer src到dst的转发管道
+type splicer struct {
+	src     *Conn
+	dst     *Conn
+	r       int   // 管道读端
+	w       int   // 管道写端
+	pending int32 // 管道中是否有dst尚未写入的数据，为1时src暂停读取
+	refs    int32 // 引用计数，src以及dst写缓存区中的管道数据各持有一个引用，为0时关闭管道
+}
+
+// Splice 将src读取到的数据通过管道使用splice(2)转发给dst，数据不经过用户态，用于TCP代理，
+// 调用之后src不再回调OnMessage，src读缓存区中尚未处理的数据会先写入dst；
+// dst写入不及时时，src暂停读取，dst写完管道中的数据之后恢复，
+// 双向代理需要再调用一次Splice(dst, src)，任意一端关闭之后，另一端需要由业务在OnClose中关闭，
+// 仅支持Linux，其他平台返回ErrSpliceNotSupported
+func Splice(src, dst *Conn) error {
+	r, w, err := newPipe()
+	if err != nil {
+		return err
+	}
+
+	s := &splicer{src: src, dst: dst, r: r, w: w, refs: 1}
+	src.spliceLock.Lock()
+	if src.isClosed() || src.splicer != nil {
+		src.spliceLock.Unlock()
+		s.release()
+		if src.isClosed() {
+			return ErrConnClosed
+		}
+		return ErrAlreadySpliced
+	}
+	src.splicer = s
+	src.spliceLock.Unlock()
+
+	// 在Handler中调用时，中断当前解码器，读缓存区中剩余的字节交给splice处理
+	atomic.AddInt32(&src.decoderVer, 1)
+	// 重新监听读事件，已经有数据可读时会产生新的读事件
+	return src.setReadInterest(true)
+}
+
+// getSplicer 获取连接的转发管道，没有调用Splice时返回nil
+func (c *Conn) getSplicer() *splicer {
+	c.spliceLock.Lock()
+	defer c.spliceLock.Unlock()
+	return c.splicer
+}
+
+// spliceRead 读事件到来时，将数据移入管道再写入dst
+func (c *Conn) spliceRead(s *splicer) error {
+	// 先转发读缓存区中已经读取的数据，以及netpoll中已经接收尚未读取的数据，之后直接从socket移入管道
+	fd := int(c.fd)
+	for {
+		if c.buffer.Len() > 0 {
+			_, err := s.dst.Write(c.buffer.ReadAll())
+			if err != nil {
+				return err
+			}
+		}
+		if c.setDirect() {
+			break
+		}
+		err := c.buffer.ReadFromFunc(func(p []byte) (int, error) {
+			return c.server.netpoll.read(fd, p)
+		})
+		if err != nil && err != syscall.EAGAIN {
+			return err
+		}
+	}
+
+	for !c.isClosed() {
+		// dst尚未写完管道中的数据
+		if atomic.LoadInt32(&s.pending) == 1 {
+			return nil
+		}
+		if c.yieldIfOverBudget() {
+			return nil
+		}
+
+		n, err := spliceFD(fd, s.w, spliceChunkLen)
+		if err != nil {
+			if err == syscall.EAGAIN {
+				return nil
+			}
+			return err
+		}
+		if n == 0 {
+			return io.EOF
+		}
+		c.readBytes += n
+
+		err = s.dst.spliceFrom(s, n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spliceFrom 将管道中的n个字节写入连接，socket缓冲区已满时剩余部分放入写缓存区，并暂停src的读取，
+// 剩余部分计入写缓存区，和Write一样受高低水位以及WithWriteBufferLimit的限制
+func (c *Conn) spliceFrom(s *splicer, n int) error {
+	c.writeLock.Lock()
+	if c.isClosing() {
+		c.writeLock.Unlock()
+		return ErrConnClosed
+	}
+
+	seg := segment{file: -1, remain: int64(n), pipe: s}
+	// 写缓存区中有数据时，需要排在后面，保证顺序
+	if len(c.outbound) == 0 {
+		err := c.splicePipe(&seg)
+		if err != syscall.EAGAIN {
+			c.writeLock.Unlock()
+			return err
+		}
+	}
+
+	// 持有写锁时暂停src，防止和flushPipe中的恢复交错
+	s.pause()
+	atomic.AddInt32(&s.refs, 1)
+	c.push(seg)
+	overflow, changed := c.checkOutbound()
+	c.writeLock.Unlock()
+
+	return c.afterEnqueue(overflow, changed)
+}
+
+// splicePipe 将管道中的数据写入socket，socket缓冲区已满时返回EAGAIN
+func (c *Conn) splicePipe(seg *segment) error {
+	for seg.remain > 0 {
+		n, err := spliceFD(seg.pipe.r, int(c.fd), int(seg.remain))
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return syscall.EAGAIN
+		}
+		seg.remain -= int64(n)
+	}
+	return nil
+}
+
+// flushPipe 写入写缓存区头部的管道数据，写完之后恢复src的读取
+func (c *Conn) flushPipe(seg *segment) error {
+	remain := seg.remain
+	err := c.splicePipe(seg)
+	c.outboundLen -= int(remain - seg.remain)
+	if err != nil {
+		return err
+	}
+
+	s := seg.pipe
+	c.pop()
+	s.resume()
+	s.release()
+	return nil
+}
+
+// pause dst写入不及时时暂停src的读取，在dst的IO goroutine中执行，
+// src可能已经关闭并且文件描述符被新连接复用，由setReadInterest在src的pollLock中检查是否已经关闭
+func (s *splicer) pause() {
+	atomic.StoreInt32(&s.pending, 1)
+	err := s.src.setReadInterest(false)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// resume dst写完管道中的数据之后恢复src的读取，重新监听读事件之后，如果有数据可读，会产生新的读事件
+func (s *splicer) resume() {
+	atomic.StoreInt32(&s.pending, 0)
+	err := s.src.setReadInterest(true)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// release 减少引用，引用为0时关闭管道
+func (s *splicer) release() {
+	if atomic.AddInt32(&s.refs, -1) == 0 {
+		_ = syscall.Close(s.r)
+		_ = syscall.Close(s.w)
+	}
+}
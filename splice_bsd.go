@@ -0,0 +1,14 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gn
+
+// newPipe 只有Linux支持splice(2)
+func newPipe() (r, w int, err error) {
+	return 0, 0, ErrSpliceNotSupported
+}
+
+// spliceFD 只有Linux支持splice(2)
+func spliceFD(in, out, n int) (int, error) {
+	return 0, ErrSpliceNotSupported
+}
@@ -0,0 +1,21 @@
+package gn
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// newPipe 创建非阻塞的管道
+func newPipe() (r, w int, err error) {
+	var fds [2]int
+	err = unix.Pipe2(fds[:], unix.O_NONBLOCK|unix.O_CLOEXEC)
+	if err != nil {
+		return 0, 0, err
+	}
+	return fds[0], fds[1], nil
+}
+
+// spliceFD 使用splice(2)从in向out移动最多n个字节
+func spliceFD(in, out, n int) (int, error) {
+	m, err := unix.Splice(in, nil, out, nil, n, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+	return int(m), err
+}
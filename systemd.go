@@ -0,0 +1,95 @@
+package gn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// systemd socket activation的环境变量，参考sd_listen_fds(3)
+const (
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+)
+
+// listenFDsStart systemd传递的第一个文件描述符，即SD_LISTEN_FDS_START，测试时可以修改
+var listenFDsStart = 3
+
+var (
+	ErrNoActivationListener = errors.New("no systemd activation listener matches names")
+)
+
+// activationListeners 获取systemd通过LISTEN_FDS传递的监听文件描述符，LISTEN_PID不是当前进程时忽略，
+// names不为空时只使用LISTEN_FDNAMES中名称匹配的文件描述符，不清除环境变量，同一个进程中的多个Server可以使用不同名称的文件描述符
+func activationListeners(names []string) ([]int, bool, error) {
+	pid, err := strconv.Atoi(os.Getenv(envListenPID))
+	if err != nil || pid != os.Getpid() {
+		return nil, false, nil
+	}
+	num, err := strconv.Atoi(os.Getenv(envListenFDs))
+	if err != nil || num <= 0 {
+		return nil, false, nil
+	}
+	fdNames := strings.Split(os.Getenv(envListenFDNames), ":")
+
+	var listenFDs []int
+	for i := 0; i < num; i++ {
+		if len(names) > 0 && (i >= len(fdNames) || !containsString(names, fdNames[i])) {
+			continue
+		}
+
+		fd := listenFDsStart + i
+		err := checkListener(fd)
+		if err != nil {
+			return nil, false, fmt.Errorf("systemd activation fd %d: %v", fd, err)
+		}
+		syscall.CloseOnExec(fd)
+		err = syscall.SetNonblock(fd, true)
+		if err != nil {
+			return nil, false, err
+		}
+		listenFDs = append(listenFDs, fd)
+	}
+	if len(listenFDs) == 0 {
+		return nil, false, ErrNoActivationListener
+	}
+	log.Info("use systemd activation listeners, fds: ", listenFDs)
+	return listenFDs, true, nil
+}
+
+// checkListener 检查文件描述符是否是正在监听的TCP socket
+func checkListener(fd int) error {
+	typ, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	if err != nil {
+		return err
+	}
+	if typ != syscall.SOCK_STREAM {
+		return errors.New("not a stream socket")
+	}
+	accepting, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN)
+	if err != nil {
+		return err
+	}
+	if accepting == 0 {
+		return errors.New("not a listening socket")
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return err
+	}
+	_, err = sockaddrString(sa)
+	return err
+}
+
+func containsString(strs []string, s string) bool {
+	for i := range strs {
+		if strs[i] == s {
+			return true
+		}
+	}
+	return false
+}
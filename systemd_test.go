@@ -0,0 +1,77 @@
+package gn
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// setActivationEnv 模拟systemd传递从fd开始的num个文件描述符
+func setActivationEnv(fd, num int, names string) func() {
+	start := listenFDsStart
+	listenFDsStart = fd
+	os.Setenv(envListenPID, strconv.Itoa(os.Getpid()))
+	os.Setenv(envListenFDs, strconv.Itoa(num))
+	os.Setenv(envListenFDNames, names)
+	return func() {
+		listenFDsStart = start
+		os.Unsetenv(envListenPID)
+		os.Unsetenv(envListenFDs)
+		os.Unsetenv(envListenFDNames)
+	}
+}
+
+func TestActivationListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	listenFD, err := detachFD(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setActivationEnv(listenFD, 1, "web")()
+
+	_, _, err = activationListeners([]string{"admin"})
+	if err != ErrNoActivationListener {
+		t.Fatal(err)
+	}
+
+	// 监听的地址由systemd传递，忽略address
+	server, err := NewServer("127.0.0.1:1", &echoHandler{}, WithActivationNames("web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Run()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("a"))
+	bytes := make([]byte, 1)
+	_, err = io.ReadFull(conn, bytes)
+	if err != nil || string(bytes) != "a" {
+		t.Fatal(string(bytes), err)
+	}
+}
+
+func TestActivationListenersInvalid(t *testing.T) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	defer setActivationEnv(fd, 1, "")()
+
+	// 没有调用listen的socket
+	_, _, err = activationListeners(nil)
+	if err == nil {
+		t.Fatal("expect error")
+	}
+}
@@ -0,0 +1,230 @@
+package gn
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	EnvListenFD  = "GN_LISTEN_FD"  // 子进程通过该环境变量继承监听的文件描述符，多个文件描述符以逗号分隔
+	EnvHandoffFD = "GN_HANDOFF_FD" // 子进程通过该环境变量继承接收已建立连接的unix socket
+)
+
+var (
+	ErrConnHandedOff   = errors.New("conn handed off to new process")
+	ErrShutdownTimeout = errors.New("shutdown timeout")
+)
+
+// handoffRecordLen 移交连接时每条消息的长度，第一个字节是对端地址的长度，之后是对端地址，文件描述符通过SCM_RIGHTS传递
+const handoffRecordLen = 64
+
+// handoff 移交连接的状态
+type handoff struct {
+	fd int            // 发送连接的unix socket
+	wg sync.WaitGroup // 等待所有IO goroutine处理完移交事件
+}
+
+// Upgrade 使用当前的可执行文件以及参数启动新进程，新进程继承监听的文件描述符，新旧进程同时接收新连接，
+// 开启WithHandoffConns时，将空闲的连接（读写缓存区都为空）移交给新进程，返回新进程，
+// 之后调用Shutdown等待剩余的连接关闭，不能在Handler中调用
+func (s *Server) Upgrade() (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	// 子进程中ExtraFiles的文件描述符从3开始
+	var listenFDs []string
+	for _, fd := range s.netpoll.listeners() {
+		listenFD, err := syscall.Dup(fd)
+		if err != nil {
+			return nil, err
+		}
+		listener := os.NewFile(uintptr(listenFD), "listener")
+		defer listener.Close()
+		listenFDs = append(listenFDs, strconv.Itoa(3+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, listener)
+	}
+	cmd.Env = append(os.Environ(), EnvListenFD+"="+strings.Join(listenFDs, ","))
+
+	var fds [2]int
+	if s.options.handoffConns {
+		fds, err = syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+		if err != nil {
+			return nil, err
+		}
+		syscall.CloseOnExec(fds[0])
+		peer := os.NewFile(uintptr(fds[1]), "handoff")
+		defer peer.Close()
+		cmd.Env = append(cmd.Env, EnvHandoffFD+"="+strconv.Itoa(3+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, peer)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		if s.options.handoffConns {
+			_ = syscall.Close(fds[0])
+		}
+		return nil, err
+	}
+	log.Info("start new process, pid: ", cmd.Process.Pid)
+
+	if s.options.handoffConns {
+		s.handoffConns(fds[0])
+		_ = syscall.Close(fds[0])
+	}
+	return cmd.Process, nil
+}
+
+// handoffConns 在每个连接的IO goroutine中移交连接，等待所有连接处理完成
+func (s *Server) handoffConns(fd int) {
+	h := &handoff{fd: fd}
+	s.handoff.Store(h)
+	defer s.handoff.Store((*handoff)(nil))
+
+	s.conns.Range(func(key, value interface{}) bool {
+		h.wg.Add(1)
+		s.handleEvent(event{FD: key.(int32), Type: EventHandoff})
+		return true
+	})
+	h.wg.Wait()
+}
+
+// handleHandoff 在连接的IO goroutine中处理移交事件，此时没有其他goroutine读取该连接，连接已经关闭时c为nil
+func (s *Server) handleHandoff(c *Conn) {
+	h := s.handoff.Load().(*handoff)
+	defer h.wg.Done()
+
+	if c == nil {
+		return
+	}
+	if c.handoff(h.fd) {
+		c.CloseWithError(ErrConnHandedOff)
+	}
+}
+
+// handoff 通过SCM_RIGHTS将空闲的连接发送给新进程，返回是否发送成功
+func (c *Conn) handoff(fd int) bool {
+	if c.proxyPending || c.getSplicer() != nil || c.buffer.Len() > 0 {
+		return false
+	}
+	// 停止io_uring尚未完成的接收以及发送请求，netpoll中还有已经接收尚未读取的数据时不能移交
+	if !c.setDirect() {
+		return false
+	}
+	// 处理异步发送的结果，发送完成之后写缓存区为空
+	if c.server.sender != nil {
+		err := c.flush()
+		if err != nil {
+			c.CloseWithError(err)
+			return false
+		}
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.isClosed() || len(c.outbound) > 0 || len(c.addr) >= handoffRecordLen {
+		return false
+	}
+
+	record := make([]byte, handoffRecordLen)
+	record[0] = byte(len(c.addr))
+	copy(record[1:], c.addr)
+	err := syscall.Sendmsg(fd, record, syscall.UnixRights(int(c.fd)), nil, 0)
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	return true
+}
+
+// receiveConns 从父进程接收移交的连接，直到父进程关闭unix socket
+func (s *Server) receiveConns(fd int) {
+	defer syscall.Close(fd)
+
+	record := make([]byte, handoffRecordLen)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	for {
+		n, oobn, _, _, err := syscall.Recvmsg(fd, record, oob, 0)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			log.Error(err)
+			return
+		}
+		if n == 0 {
+			return
+		}
+
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil || len(msgs) == 0 {
+			log.Error("invalid handoff message: ", err)
+			continue
+		}
+		fds, err := syscall.ParseUnixRights(&msgs[0])
+		if err != nil || len(fds) == 0 {
+			log.Error("invalid handoff message: ", err)
+			continue
+		}
+		syscall.CloseOnExec(fds[0])
+
+		addr := string(record[1 : 1+int(record[0])])
+		err = s.addConn(fds[0], addr, false)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// inheritedHandoff 获取通过环境变量EnvHandoffFD从父进程继承的unix socket，获取之后清除环境变量
+func inheritedHandoff() (int, bool) {
+	value := os.Getenv(EnvHandoffFD)
+	if value == "" {
+		return 0, false
+	}
+	_ = os.Unsetenv(EnvHandoffFD)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		log.Error(err)
+		return 0, false
+	}
+	syscall.CloseOnExec(fd)
+	return fd, true
+}
+
+// Shutdown 优雅关闭，停止接收新连接，等待已建立的连接关闭，超过timeout之后关闭剩余的连接并返回ErrShutdownTimeout
+func (s *Server) Shutdown(timeout time.Duration) error {
+	s.stopAcceptOnce.Do(func() {
+		close(s.stopAccept)
+	})
+	// 等待accept goroutine退出，之后接收的连接也需要等待关闭
+	s.acceptWG.Wait()
+	s.netpoll.stopAccept()
+
+	deadline := time.Now().Add(timeout)
+	for s.GetConnsNum() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var err error
+	if s.GetConnsNum() > 0 {
+		err = ErrShutdownTimeout
+		s.conns.Range(func(key, value interface{}) bool {
+			value.(*Conn).CloseWithError(ErrShutdownTimeout)
+			return true
+		})
+	}
+	s.Stop()
+	return err
+}
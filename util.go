@@ -2,32 +2,48 @@ package gn
 
 import (
 	"errors"
-	"strconv"
-	"strings"
+	"net"
+	"syscall"
 )
 
-func getIPPort(addr string) (ip [4]byte, port int, err error) {
-	strs := strings.Split(addr, ":")
-	if len(strs) != 2 {
-		err = errors.New("addr error")
-		return
+// resolveSockaddr 解析监听地址，地址中没有IP时监听所有IPv4地址
+func resolveSockaddr(address string) (family int, sa syscall.Sockaddr, err error) {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return 0, nil, err
 	}
 
-	if len(strs[0]) != 0 {
-		ips := strings.Split(strs[0], ".")
-		if len(ips) != 4 {
-			err = errors.New("addr error")
-			return
+	if addr.IP == nil || addr.IP.To4() != nil {
+		sa4 := &syscall.SockaddrInet4{Port: addr.Port}
+		copy(sa4.Addr[:], addr.IP.To4())
+		return syscall.AF_INET, sa4, nil
+	}
+
+	sa6 := &syscall.SockaddrInet6{Port: addr.Port}
+	copy(sa6.Addr[:], addr.IP.To16())
+	if addr.Zone != "" {
+		ifi, err := net.InterfaceByName(addr.Zone)
+		if err != nil {
+			return 0, nil, err
 		}
-		for i := range ips {
-			data, err := strconv.Atoi(ips[i])
-			if err != nil {
-				return ip, 0, err
+		sa6.ZoneId = uint32(ifi.Index)
+	}
+	return syscall.AF_INET6, sa6, nil
+}
+
+// sockaddrString 将socket地址转换为"ip:port"格式的字符串，IPv6地址为"[ip]:port"
+func sockaddrString(sa syscall.Sockaddr) (string, error) {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return (&net.TCPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port}).String(), nil
+	case *syscall.SockaddrInet6:
+		addr := &net.TCPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port}
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				addr.Zone = ifi.Name
 			}
-			ip[i] = byte(data)
 		}
+		return addr.String(), nil
 	}
-
-	port, err = strconv.Atoi(strs[1])
-	return
+	return "", errors.New("unsupported address family")
 }
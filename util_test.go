@@ -1,12 +1,31 @@
 package gn
 
 import (
-	"fmt"
+	"syscall"
 	"testing"
 )
 
-func Test_getIPPort(t *testing.T) {
-	fmt.Println(getIPPort(":1111"))
-	fmt.Println(getIPPort("111.00.00.00:1111"))
-	fmt.Println(getIPPort("1:1111"))
+func Test_resolveSockaddr(t *testing.T) {
+	for address, want := range map[string]string{
+		":1111":             "0.0.0.0:1111",
+		"111.0.0.1:1111":    "111.0.0.1:1111",
+		"[::1]:1111":        "[::1]:1111",
+		"[2001:db8::]:1111": "[2001:db8::]:1111",
+	} {
+		_, sa, err := resolveSockaddr(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := sockaddrString(sa)
+		if err != nil || s != want {
+			t.Fatal(address, s, err)
+		}
+	}
+
+	if family, _, _ := resolveSockaddr("[::]:1111"); family != syscall.AF_INET6 {
+		t.Fatal(family)
+	}
+	if _, _, err := resolveSockaddr("1:1111:1"); err == nil {
+		t.Fatal("expect error")
+	}
 }
@@ -0,0 +1,262 @@
+package websocket
+
+import (
+	"bytes"
+	"github.com/alberliu/gn"
+	"github.com/alberliu/gn/codec"
+	"unicode/utf8"
+)
+
+// decoder 每个连接的解码器，在读缓存区上直接解析握手请求以及数据帧，不复制字节，
+// 超过读缓存区容量的数据帧分多次读取，负载追加到消息中
+type decoder struct {
+	handler *Handler
+	conn    *gn.Conn
+	state   *connState
+}
+
+// Decode 解码握手请求以及数据帧，完整的消息通过handle交给Handler
+func (d *decoder) Decode(buffer *codec.Buffer, handle func([]byte)) error {
+	st := d.state
+	if !st.upgraded && !d.handshake(buffer) {
+		return nil
+	}
+
+	for !st.isClosed() {
+		var err error
+		if st.remain > 0 {
+			err = d.readPayload(buffer, handle)
+		} else {
+			err = d.readFrame(buffer, handle)
+		}
+		if err == codec.ErrNotEnough {
+			return nil
+		}
+		if err != nil {
+			d.handler.fail(d.conn, err)
+			return nil
+		}
+	}
+	return nil
+}
+
+// handshake 处理握手请求，握手完成时返回true
+func (d *decoder) handshake(buffer *codec.Buffer) bool {
+	h, c, st := d.handler, d.conn, d.state
+	r, n, err := parseRequest(buffer.GetBytes())
+	if err != nil {
+		h.reject(c, "400 Bad Request")
+		return false
+	}
+	if r == nil {
+		// 读缓存区已满，请求头超过了读缓存区的容量
+		if buffer.Len() >= buffer.Cap() {
+			h.reject(c, "431 Request Header Fields Too Large")
+		}
+		return false
+	}
+	_, _ = buffer.Read(0, n)
+
+	if r.method != "GET" || r.proto != "HTTP/1.1" ||
+		!headerContains(r.header, "Connection", "upgrade") ||
+		!headerContains(r.header, "Upgrade", "websocket") ||
+		r.header.Get("Sec-WebSocket-Key") == "" {
+		h.reject(c, "400 Bad Request")
+		return false
+	}
+	if r.header.Get("Sec-WebSocket-Version") != "13" {
+		h.reject(c, "426 Upgrade Required")
+		return false
+	}
+	if h.checkOrigin != nil && !h.checkOrigin(r.header.Get("Origin")) {
+		h.reject(c, "403 Forbidden")
+		return false
+	}
+
+	st.deflate = h.compression && acceptDeflate(r.header)
+	_, err = c.WritevRaw([][]byte{handshakeResponse(r.header.Get("Sec-WebSocket-Key"), st.deflate)})
+	if err != nil {
+		c.CloseWithError(err)
+		return false
+	}
+
+	st.upgraded = true
+	// 之后Conn.Write写入的数据以二进制消息发送
+	c.SetFramer(&framer{deflate: st.deflate})
+	h.handler.OnConnect(c)
+	return true
+}
+
+// readFrame 读取一个数据帧，超过读缓存区容量的帧只读取头部，负载由readPayload分多次读取
+func (d *decoder) readFrame(buffer *codec.Buffer, handle func([]byte)) error {
+	h, err := parseFrameHeader(buffer.GetBytes(), d.handler.maxMessageSize)
+	if err == errIncompleteFrame {
+		return codec.ErrNotEnough
+	}
+	if err != nil {
+		return err
+	}
+
+	if h.len+h.payloadLen > buffer.Cap() {
+		_, _ = buffer.Read(h.len, 0)
+		return d.startPayload(h)
+	}
+
+	payload, err := buffer.Read(h.len, h.payloadLen)
+	if err != nil {
+		return err
+	}
+	unmask(payload, h.mask, 0)
+	return d.handleFrame(&frame{fin: h.fin, rsv1: h.rsv1, opcode: h.opcode, payload: payload}, handle)
+}
+
+// startPayload 开始分多次读取一个超过读缓存区容量的数据帧，按照分片消息处理
+func (d *decoder) startPayload(h frameHeader) error {
+	st := d.state
+	// 控制帧不超过125字节，读缓存区小于控制帧的最大长度时不支持
+	if h.opcode.isControl() {
+		return ErrProtocol
+	}
+	if h.rsv1 && (!st.deflate || h.opcode == OpContinuation) {
+		return ErrProtocol
+	}
+
+	if h.opcode == OpContinuation {
+		if !st.fragmented {
+			return ErrProtocol
+		}
+	} else {
+		if st.fragmented {
+			return ErrProtocol
+		}
+		st.fragmented = true
+		st.opcode = h.opcode
+		st.compressed = h.rsv1
+		st.message = st.message[:0]
+	}
+	if len(st.message)+h.payloadLen > d.handler.maxMessageSize {
+		return ErrMessageTooBig
+	}
+
+	st.remain = h.payloadLen
+	st.fin = h.fin
+	st.mask = h.mask
+	st.maskPos = 0
+	return nil
+}
+
+// readPayload 读取startPayload开始的数据帧的负载，帧读取完成并且是消息的最后一帧时交给handler
+func (d *decoder) readPayload(buffer *codec.Buffer, handle func([]byte)) error {
+	st := d.state
+	n := buffer.Len()
+	if n == 0 {
+		return codec.ErrNotEnough
+	}
+	if n > st.remain {
+		n = st.remain
+	}
+
+	payload, _ := buffer.Read(0, n)
+	unmask(payload, st.mask, st.maskPos)
+	st.message = append(st.message, payload...)
+	st.maskPos += n
+	st.remain -= n
+	if st.remain > 0 || !st.fin {
+		return nil
+	}
+	st.fragmented = false
+	return d.deliver(st.opcode, st.compressed, st.message, handle)
+}
+
+// handleFrame 处理一个完整的数据帧
+func (d *decoder) handleFrame(f *frame, handle func([]byte)) error {
+	h, c, st := d.handler, d.conn, d.state
+	// 只有消息的第一帧可以设置压缩标记
+	if f.rsv1 && (!st.deflate || f.opcode.isControl() || f.opcode == OpContinuation) {
+		return ErrProtocol
+	}
+
+	switch f.opcode {
+	case OpPing:
+		return h.WriteMessage(c, OpPong, f.payload)
+	case OpPong:
+		return nil
+	case OpClose:
+		code, reason, err := parseClosePayload(f.payload)
+		if err != nil {
+			return err
+		}
+		if !utf8.ValidString(reason) {
+			return ErrInvalidUTF8
+		}
+		h.Close(c, code, reason)
+		return nil
+	case OpContinuation:
+		if !st.fragmented {
+			return ErrProtocol
+		}
+		if len(st.message)+len(f.payload) > h.maxMessageSize {
+			return ErrMessageTooBig
+		}
+		st.message = append(st.message, f.payload...)
+		if !f.fin {
+			return nil
+		}
+		st.fragmented = false
+		return d.deliver(st.opcode, st.compressed, st.message, handle)
+	default:
+		if st.fragmented {
+			return ErrProtocol
+		}
+		if !f.fin {
+			st.fragmented = true
+			st.opcode = f.opcode
+			st.compressed = f.rsv1
+			st.message = append(st.message[:0], f.payload...)
+			return nil
+		}
+		return d.deliver(f.opcode, f.rsv1, f.payload, handle)
+	}
+}
+
+// deliver 将完整的消息通过handle交给handler
+func (d *decoder) deliver(opcode Opcode, compressed bool, payload []byte, handle func([]byte)) error {
+	if compressed {
+		var err error
+		payload, err = decompress(payload, d.handler.maxMessageSize)
+		if err != nil {
+			if err == ErrMessageTooBig {
+				return err
+			}
+			return ErrProtocol
+		}
+	}
+	if opcode == OpText && !utf8.Valid(payload) {
+		return ErrInvalidUTF8
+	}
+
+	handle(payload)
+	return nil
+}
+
+// framer 握手完成之后设置到连接上，Conn.Write写入的数据封装成一个二进制消息
+type framer struct {
+	deflate bool // 是否协商了permessage-deflate
+}
+
+// Frame 在数据前面加上帧头部，协商了压缩时先压缩数据
+func (f *framer) Frame(buffers [][]byte) ([][]byte, error) {
+	if f.deflate {
+		data, err := compress(bytes.Join(buffers, nil))
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{encodeFrameHeader(OpBinary, true, len(data)), data}, nil
+	}
+
+	n := 0
+	for _, b := range buffers {
+		n += len(b)
+	}
+	return append([][]byte{encodeFrameHeader(OpBinary, false, n)}, buffers...), nil
+}
@@ -0,0 +1,52 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+// deflateTail permessage-deflate压缩时去掉的尾部，解压时需要补上，再加一个空的final块结束解压
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var flateWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// compress 压缩消息，每个消息独立压缩（no_context_takeover）
+func compress(payload []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(w)
+	w.Reset(buf)
+
+	_, err := w.Write(payload)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail[:4]), nil
+}
+
+// decompress 解压消息，解压后超过maxLen时返回ErrMessageTooBig
+func decompress(payload []byte, maxLen int) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateTail)))
+	defer r.Close()
+
+	buf := &bytes.Buffer{}
+	n, err := io.Copy(buf, io.LimitReader(r, int64(maxLen)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(maxLen) {
+		return nil, ErrMessageTooBig
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,189 @@
+package websocket
+
+import (
+	"encoding/binary"
+)
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsvMask = 0x70
+	maskBit = 0x80
+
+	maxControlPayloadLen = 125
+)
+
+// frame 数据帧
+type frame struct {
+	fin     bool   // 是否是消息的最后一帧
+	rsv1    bool   // permessage-deflate中表示消息被压缩
+	opcode  Opcode // 帧类型
+	payload []byte // 已经去掉掩码的负载，引用原字节数组
+}
+
+// frameHeader 客户端帧的头部
+type frameHeader struct {
+	fin        bool    // 是否是消息的最后一帧
+	rsv1       bool    // permessage-deflate中表示消息被压缩
+	opcode     Opcode  // 帧类型
+	payloadLen int     // 负载长度
+	mask       [4]byte // 掩码
+	len        int     // 头部占用的字节数
+}
+
+// parseFrameHeader 从字节数组中解析一个客户端帧的头部
+// maxPayloadLen 允许的最大负载长度，超过时返回ErrMessageTooBig
+// 头部不完整时返回errIncompleteFrame
+func parseFrameHeader(buf []byte, maxPayloadLen int) (frameHeader, error) {
+	var h frameHeader
+	if len(buf) < 2 {
+		return h, errIncompleteFrame
+	}
+
+	h.fin = buf[0]&finBit != 0
+	h.rsv1 = buf[0]&rsv1Bit != 0
+	h.opcode = Opcode(buf[0] & 0x0f)
+	// RSV2、RSV3没有协商任何扩展，必须为0
+	if buf[0]&rsvMask&^rsv1Bit != 0 {
+		return h, ErrProtocol
+	}
+	// 客户端发送的帧必须带掩码
+	if buf[1]&maskBit == 0 {
+		return h, ErrProtocol
+	}
+
+	switch h.opcode {
+	case OpContinuation, OpText, OpBinary:
+	case OpClose, OpPing, OpPong:
+		// 控制帧不能分片
+		if !h.fin {
+			return h, ErrProtocol
+		}
+	default:
+		return h, ErrProtocol
+	}
+
+	offset := 2
+	payloadLen := uint64(buf[1] & 0x7f)
+	switch payloadLen {
+	case 126:
+		if len(buf) < offset+2 {
+			return h, errIncompleteFrame
+		}
+		payloadLen = uint64(binary.BigEndian.Uint16(buf[offset:]))
+		offset += 2
+	case 127:
+		if len(buf) < offset+8 {
+			return h, errIncompleteFrame
+		}
+		payloadLen = binary.BigEndian.Uint64(buf[offset:])
+		offset += 8
+	}
+	if h.opcode.isControl() && payloadLen > maxControlPayloadLen {
+		return h, ErrProtocol
+	}
+	if payloadLen > uint64(maxPayloadLen) {
+		return h, ErrMessageTooBig
+	}
+
+	if len(buf) < offset+4 {
+		return h, errIncompleteFrame
+	}
+	copy(h.mask[:], buf[offset:offset+4])
+	h.payloadLen = int(payloadLen)
+	h.len = offset + 4
+	return h, nil
+}
+
+// unmask 在原字节数组上去掉掩码，pos是payload在整个负载中的偏移，用于分多次读取的负载
+func unmask(payload []byte, mask [4]byte, pos int) {
+	for i := range payload {
+		payload[i] ^= mask[(pos+i)&3]
+	}
+}
+
+// parseFrame 从字节数组中解析一个完整的客户端帧，并在原字节数组上去掉掩码
+// 返回帧以及帧占用的字节数，帧不完整时返回errIncompleteFrame
+func parseFrame(buf []byte, maxPayloadLen int) (*frame, int, error) {
+	h, err := parseFrameHeader(buf, maxPayloadLen)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := h.len + h.payloadLen
+	if len(buf) < n {
+		return nil, 0, errIncompleteFrame
+	}
+
+	f := &frame{fin: h.fin, rsv1: h.rsv1, opcode: h.opcode, payload: buf[h.len:n]}
+	unmask(f.payload, h.mask, 0)
+	return f, n, nil
+}
+
+// encodeFrameHeader 编码一个服务端帧的头部，服务端发送的帧不带掩码
+func encodeFrameHeader(opcode Opcode, compressed bool, payloadLen int) []byte {
+	headerLen := 2
+	switch {
+	case payloadLen > 0xffff:
+		headerLen += 8
+	case payloadLen > 125:
+		headerLen += 2
+	}
+
+	buf := make([]byte, headerLen)
+	buf[0] = finBit | byte(opcode)
+	if compressed {
+		buf[0] |= rsv1Bit
+	}
+	switch headerLen {
+	case 2:
+		buf[1] = byte(payloadLen)
+	case 4:
+		buf[1] = 126
+		binary.BigEndian.PutUint16(buf[2:], uint16(payloadLen))
+	default:
+		buf[1] = 127
+		binary.BigEndian.PutUint64(buf[2:], uint64(payloadLen))
+	}
+	return buf
+}
+
+// closePayload 编码关闭帧的负载
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatusReceived {
+		return nil
+	}
+	if len(reason) > maxControlPayloadLen-2 {
+		reason = reason[:maxControlPayloadLen-2]
+	}
+	buf := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(buf, uint16(code))
+	copy(buf[2:], reason)
+	return buf
+}
+
+// parseClosePayload 解析关闭帧的负载
+func parseClosePayload(payload []byte) (int, string, error) {
+	if len(payload) == 0 {
+		return CloseNoStatusReceived, "", nil
+	}
+	if len(payload) == 1 {
+		return 0, "", ErrProtocol
+	}
+
+	code := int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return 0, "", ErrProtocol
+	}
+	return code, string(payload[2:]), nil
+}
+
+// validCloseCode 判断客户端发送的关闭状态码是否合法
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
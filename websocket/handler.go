@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"errors"
+	"github.com/alberliu/gn"
+	"github.com/alberliu/gn/codec"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var log = gn.GetLogger()
+
+// Option WebSocket参数
+type Option func(*Handler)
+
+// WithMaxMessageSize 设置消息的最大长度，默认值是1M，超过时以1009关闭连接
+func WithMaxMessageSize(size int) Option {
+	return func(h *Handler) {
+		if size <= 0 {
+			panic("maxMessageSize must greater than 0")
+		}
+		h.maxMessageSize = size
+	}
+}
+
+// WithCompression 开启permessage-deflate压缩，客户端支持时生效
+func WithCompression() Option {
+	return func(h *Handler) {
+		h.compression = true
+	}
+}
+
+// WithCheckOrigin 设置Origin校验函数，返回false时握手以403失败
+func WithCheckOrigin(checkOrigin func(origin string) bool) Option {
+	return func(h *Handler) {
+		h.checkOrigin = checkOrigin
+	}
+}
+
+// connState 每个连接的WebSocket状态
+type connState struct {
+	closed     int32   // 是否已经关闭，关闭之后不再处理数据帧，保证handler的OnClose只回调一次
+	upgraded   bool    // 是否已经完成握手
+	deflate    bool    // 是否协商了permessage-deflate
+	message    []byte  // 分片消息的已接收部分
+	opcode     Opcode  // 分片消息的类型
+	compressed bool    // 分片消息是否被压缩
+	fragmented bool    // 是否正在接收分片消息
+	remain     int     // 正在分多次读取的数据帧剩余的负载长度
+	fin        bool    // 正在分多次读取的数据帧是否是消息的最后一帧
+	mask       [4]byte // 正在分多次读取的数据帧的掩码
+	maskPos    int     // 正在分多次读取的数据帧已经读取的负载长度
+}
+
+// close 标记为已经关闭，返回是否是本次调用关闭的
+func (st *connState) close() bool {
+	return atomic.CompareAndSwapInt32(&st.closed, 0, 1)
+}
+
+func (st *connState) isClosed() bool {
+	return atomic.LoadInt32(&st.closed) == 1
+}
+
+// Handler WebSocket协议适配，实现了gn.Handler，握手完成之后，handler的OnMessage收到的是完整的消息
+// Handler在OnConnect中为连接设置自己的解码器，在读缓存区上处理握手以及帧的拆包，读缓存区不能小于139字节（控制帧的最大长度），
+// 握手完成之后Conn.Write写入的数据以二进制消息发送
+type Handler struct {
+	handler        gn.Handler               // 业务处理
+	maxMessageSize int                      // 消息的最大长度
+	compression    bool                     // 是否开启压缩
+	checkOrigin    func(origin string) bool // Origin校验
+	states         sync.Map                 // 连接状态，key为*gn.Conn
+}
+
+// NewHandler 创建WebSocket协议适配
+func NewHandler(handler gn.Handler, opts ...Option) *Handler {
+	h := &Handler{
+		handler:        handler,
+		maxMessageSize: 1 << 20,
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	return h
+}
+
+// OnConnect 当TCP长连接建立成功是回调，握手完成之后才会回调handler的OnConnect
+func (h *Handler) OnConnect(c *gn.Conn) {
+	st := &connState{}
+	h.states.Store(c, st)
+	c.SetDecoder(&decoder{handler: h, conn: c, state: st})
+}
+
+// OnMessage 解码器解出的完整消息，交给handler
+func (h *Handler) OnMessage(c *gn.Conn, bytes []byte) {
+	h.handler.OnMessage(c, bytes)
+}
+
+// OnClose 当客户端主动断开链接或者超时时回调
+func (h *Handler) OnClose(c *gn.Conn, err error) {
+	v, ok := h.states.Load(c)
+	if !ok {
+		return
+	}
+	h.closeState(c, v.(*connState), err)
+}
+
+// closeState 删除连接状态，已经完成握手时回调handler的OnClose
+func (h *Handler) closeState(c *gn.Conn, st *connState, err error) {
+	if !st.close() {
+		return
+	}
+	h.states.Delete(c)
+	if st.upgraded {
+		h.handler.OnClose(c, err)
+	}
+}
+
+// WriteMessage 发送消息
+func (h *Handler) WriteMessage(c *gn.Conn, opcode Opcode, data []byte) error {
+	v, ok := h.states.Load(c)
+	if !ok || !v.(*connState).upgraded {
+		return ErrNotUpgraded
+	}
+
+	compressed := false
+	if v.(*connState).deflate && !opcode.isControl() {
+		var err error
+		data, err = compress(data)
+		if err != nil {
+			return err
+		}
+		compressed = true
+	}
+	// 控制帧以及指定类型的消息不经过连接的分帧器
+	_, err := c.WritevRaw([][]byte{encodeFrameHeader(opcode, compressed, len(data)), data})
+	return err
+}
+
+// WriteText 发送文本消息
+func (h *Handler) WriteText(c *gn.Conn, text string) error {
+	return h.WriteMessage(c, OpText, []byte(text))
+}
+
+// WriteBinary 发送二进制消息
+func (h *Handler) WriteBinary(c *gn.Conn, data []byte) error {
+	return h.WriteMessage(c, OpBinary, data)
+}
+
+// Close 发送关闭帧，关闭帧写入之后关闭连接，handler的OnClose会收到*CloseError
+func (h *Handler) Close(c *gn.Conn, code int, reason string) {
+	_ = h.WriteMessage(c, OpClose, closePayload(code, reason))
+	c.CloseAfterFlush()
+	// CloseAfterFlush不会回调OnClose，在这里回调handler的OnClose
+	h.OnClose(c, &CloseError{Code: code, Reason: reason})
+}
+
+// Encoder 返回编码器，配合gn.WithEncoder使用之后，Conn.WriteWithEncoder会以opcode类型的消息发送
+func (h *Handler) Encoder(opcode Opcode) codec.Encoder {
+	return &encoder{handler: h, opcode: opcode}
+}
+
+type encoder struct {
+	handler *Handler
+	opcode  Opcode
+}
+
+// EncodeToWriter 编码数据,并且写入Writer，Writer必须是*gn.Conn
+func (e *encoder) EncodeToWriter(w io.Writer, bytes []byte) error {
+	c, ok := w.(*gn.Conn)
+	if !ok {
+		return errors.New("websocket encoder writer must be *gn.Conn")
+	}
+	return e.handler.WriteMessage(c, e.opcode, bytes)
+}
+
+// reject 握手失败，返回HTTP错误，响应写入之后关闭连接
+func (h *Handler) reject(c *gn.Conn, status string) {
+	_, _ = c.WritevRaw([][]byte{errorResponse(status)})
+	c.CloseAfterFlush()
+	h.OnClose(c, ErrHandshake)
+}
+
+// fail 协议错误时发送关闭帧并关闭连接
+func (h *Handler) fail(c *gn.Conn, err error) {
+	code := CloseProtocolError
+	switch err {
+	case ErrMessageTooBig:
+		code = CloseMessageTooBig
+	case ErrInvalidUTF8:
+		code = CloseInvalidPayloadData
+	}
+	log.Debug(err)
+	h.Close(c, code, strings.TrimPrefix(err.Error(), "websocket "))
+}
@@ -0,0 +1,127 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/textproto"
+	"strings"
+)
+
+const (
+	acceptGUID      = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	maxHeaderLen    = 8192 // 握手请求的最大长度
+	deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+)
+
+var headerEnd = []byte("\r\n\r\n")
+
+// request 握手请求
+type request struct {
+	method string
+	uri    string
+	proto  string
+	header textproto.MIMEHeader
+}
+
+// parseRequest 从字节数组中解析HTTP/1.1握手请求，返回请求以及请求占用的字节数，请求不完整时返回0
+func parseRequest(buf []byte) (*request, int, error) {
+	end := bytes.Index(buf, headerEnd)
+	if end < 0 {
+		if len(buf) > maxHeaderLen {
+			return nil, 0, ErrHandshake
+		}
+		return nil, 0, nil
+	}
+
+	lines := strings.Split(string(buf[:end]), "\r\n")
+	parts := strings.Split(lines[0], " ")
+	if len(parts) != 3 {
+		return nil, 0, ErrHandshake
+	}
+	r := &request{
+		method: parts[0],
+		uri:    parts[1],
+		proto:  parts[2],
+		header: make(textproto.MIMEHeader),
+	}
+	for _, line := range lines[1:] {
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, 0, ErrHandshake
+		}
+		r.header.Add(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]))
+	}
+	return r, end + len(headerEnd), nil
+}
+
+// headerContains 判断逗号分隔的请求头中是否包含token，忽略大小写
+func headerContains(header textproto.MIMEHeader, key, token string) bool {
+	for _, value := range header[textproto.CanonicalMIMEHeaderKey(key)] {
+		for _, s := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acceptKey 根据Sec-WebSocket-Key计算Sec-WebSocket-Accept
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// acceptDeflate 判断客户端是否提供了可以接受的permessage-deflate扩展
+// 只支持no_context_takeover模式，每个消息独立压缩，不需要保存压缩上下文
+func acceptDeflate(header textproto.MIMEHeader) bool {
+	for _, value := range header["Sec-Websocket-Extensions"] {
+		for _, offer := range strings.Split(value, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+
+			ok := true
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				// flate只支持32K的窗口
+				if strings.HasPrefix(param, "server_max_window_bits") && param != "server_max_window_bits" &&
+					param != "server_max_window_bits=15" {
+					ok = false
+				}
+			}
+			if ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handshakeResponse 生成101响应
+func handshakeResponse(key string, deflate bool) []byte {
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	b.WriteString(acceptKey(key))
+	b.WriteString("\r\n")
+	if deflate {
+		b.WriteString("Sec-WebSocket-Extensions: ")
+		b.WriteString(deflateResponse)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// errorResponse 生成握手失败的响应
+func errorResponse(status string) []byte {
+	header := ""
+	if strings.HasPrefix(status, "426") {
+		header = "Sec-WebSocket-Version: 13\r\n"
+	}
+	return []byte("HTTP/1.1 " + status + "\r\n" + header + "Connection: close\r\nContent-Length: 0\r\n\r\n")
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Opcode 帧类型
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+// 关闭状态码，参考RFC 6455 7.4.1
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseInvalidPayloadData = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseInternalServerErr  = 1011
+)
+
+var (
+	ErrHandshake       = errors.New("websocket handshake failed")
+	ErrProtocol        = errors.New("websocket protocol error")
+	ErrMessageTooBig   = errors.New("websocket message too big")
+	ErrInvalidUTF8     = errors.New("websocket invalid utf8 text")
+	ErrNotUpgraded     = errors.New("websocket not upgraded")
+	errIncompleteFrame = errors.New("websocket incomplete frame")
+)
+
+// CloseError 收到或者发送关闭帧时，OnClose返回的错误
+type CloseError struct {
+	Code   int    // 关闭状态码
+	Reason string // 关闭原因
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket close %d %s", e.Code, e.Reason)
+}
+
+func (op Opcode) isControl() bool {
+	return op&0x8 != 0
+}
@@ -0,0 +1,222 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/alberliu/gn"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_acceptKey(t *testing.T) {
+	// RFC 6455 1.3中的例子
+	if acceptKey("dGhlIHNhbXBsZSBub25jZQ==") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal(acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+	}
+}
+
+func Test_parseFrame(t *testing.T) {
+	// RFC 6455 5.7中带掩码的"Hello"
+	buf := []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}
+	_, _, err := parseFrame(buf[:5], 1024)
+	if err != errIncompleteFrame {
+		t.Fatal(err)
+	}
+	f, n, err := parseFrame(buf, 1024)
+	if err != nil || n != len(buf) || !f.fin || f.opcode != OpText || string(f.payload) != "Hello" {
+		t.Fatal(f, n, err)
+	}
+	_, _, err = parseFrame([]byte{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'}, 1024)
+	if err != ErrProtocol {
+		t.Fatal("unmasked frame accepted")
+	}
+}
+
+func Test_compress(t *testing.T) {
+	payload := bytes.Repeat([]byte("hello"), 100)
+	compressed, err := compress(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := decompress(compressed, len(payload))
+	if err != nil || !bytes.Equal(decompressed, payload) {
+		t.Fatal(err)
+	}
+	_, err = decompress(compressed, len(payload)-1)
+	if err != ErrMessageTooBig {
+		t.Fatal(err)
+	}
+}
+
+type echoHandler struct {
+	ws *Handler
+}
+
+func (*echoHandler) OnConnect(c *gn.Conn) {}
+
+func (h *echoHandler) OnMessage(c *gn.Conn, bytes []byte) {
+	h.ws.WriteText(c, string(bytes))
+}
+
+func (*echoHandler) OnClose(c *gn.Conn, err error) {}
+
+// maskFrame 生成客户端帧
+func maskFrame(fin bool, opcode Opcode, payload string) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= finBit
+	}
+	frame := []byte{b0, maskBit | byte(len(payload))}
+	if len(payload) > 125 {
+		frame[1] = maskBit | 126
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i := range payload {
+		frame = append(frame, payload[i]^mask[i&3])
+	}
+	return frame
+}
+
+const upgradeRequest = "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+
+// dial 启动服务并完成握手，返回握手之后的连接以及读取器
+func dial(t *testing.T, handler gn.Handler, opts ...gn.Option) (net.Conn, *bufio.Reader) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	server, err := gn.NewServer(address, handler, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Run()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte(upgradeRequest))
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal(resp.Status)
+	}
+	return conn, reader
+}
+
+func TestHandler(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	h := &echoHandler{}
+	h.ws = NewHandler(h)
+	server, err := gn.NewServer(address, h.ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Run()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	conn.Write([]byte(upgradeRequest))
+	// 分片发送的文本消息
+	conn.Write(maskFrame(false, OpText, "hel"))
+	conn.Write(maskFrame(true, OpContinuation, "lo"))
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal(resp.Status, resp.Header)
+	}
+
+	buf := make([]byte, 7)
+	_, err = reader.Read(buf)
+	if err != nil || !bytes.Equal(buf, []byte{0x81, 5, 'h', 'e', 'l', 'l', 'o'}) {
+		t.Fatal(buf, err)
+	}
+}
+
+func TestHandler_largeFrame(t *testing.T) {
+	h := &echoHandler{}
+	h.ws = NewHandler(h)
+	conn, reader := dial(t, h.ws, gn.WithReadBufferLen(256))
+	defer conn.Close()
+
+	// 超过读缓存区容量的帧分多次读取
+	text := string(bytes.Repeat([]byte("hello"), 200))
+	conn.Write(maskFrame(true, OpText, text))
+
+	buf := make([]byte, 4+len(text))
+	_, err := io.ReadFull(reader, buf)
+	if err != nil || buf[0] != 0x81 || buf[1] != 126 || string(buf[4:]) != text {
+		t.Fatal(buf[:4], err)
+	}
+}
+
+type writeHandler struct {
+	closeErr chan error
+}
+
+func (*writeHandler) OnConnect(c *gn.Conn) {}
+
+func (*writeHandler) OnMessage(c *gn.Conn, bytes []byte) {
+	c.Write(bytes)
+}
+
+func (h *writeHandler) OnClose(c *gn.Conn, err error) {
+	h.closeErr <- err
+}
+
+func TestHandler_write(t *testing.T) {
+	h := &writeHandler{closeErr: make(chan error, 1)}
+	conn, reader := dial(t, NewHandler(h))
+	defer conn.Close()
+
+	// Conn.Write写入的数据以二进制消息发送
+	conn.Write(maskFrame(true, OpText, "hello"))
+	buf := make([]byte, 7)
+	_, err := io.ReadFull(reader, buf)
+	if err != nil || !bytes.Equal(buf, []byte{0x82, 5, 'h', 'e', 'l', 'l', 'o'}) {
+		t.Fatal(buf, err)
+	}
+
+	// 关闭帧写入之后关闭连接
+	conn.Write(maskFrame(true, OpClose, "\x03\xe8bye"))
+	buf, err = ioutil.ReadAll(reader)
+	if err != nil || !bytes.Equal(buf, []byte{0x88, 5, 0x03, 0xe8, 'b', 'y', 'e'}) {
+		t.Fatal(buf, err)
+	}
+	closeErr, ok := (<-h.closeErr).(*CloseError)
+	if !ok || closeErr.Code != CloseNormalClosure || closeErr.Reason != "bye" {
+		t.Fatal(closeErr)
+	}
+}
@@ -0,0 +1,389 @@
+package gn
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+)
+
+var (
+	ErrConnClosed          = errors.New("conn closed")
+	ErrWriteBufferOverflow = errors.New("write buffer overflow")
+)
+
+// errSending 写缓存区头部的数据正在异步发送，发送完成之后产生可写事件
+var errSending = errors.New("sending")
+
+// WritabilityHandler Handler可以选择实现的接口，连接的写缓存区超过高水位时以writable为false回调，
+// 降到低水位以下时以writable为true回调
+type WritabilityHandler interface {
+	OnWritabilityChanged(c *Conn, writable bool)
+}
+
+// getWritabilityHandler 优先使用中间件包装之后的handler，中间件没有转发时使用原始的handler
+func getWritabilityHandler(wrapped, handler Handler) WritabilityHandler {
+	if h, ok := wrapped.(WritabilityHandler); ok {
+		return h
+	}
+	if h, ok := handler.(WritabilityHandler); ok {
+		return h
+	}
+	return nil
+}
+
+// IsWritable 写缓存区是否低于高水位，返回false时应当暂停写入，等待OnWritabilityChanged回调
+func (c *Conn) IsWritable() bool {
+	return atomic.LoadInt32(&c.unwritable) == 0
+}
+
+// GetWriteBufferLen 获取写缓存区中等待写入的字节数
+func (c *Conn) GetWriteBufferLen() int {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.outboundLen
+}
+
+// maxIovecs 每次writev最多写入的字节数组数量，即IOV_MAX
+const maxIovecs = 1024
+
+// writeBuffers 写入多个字节数组，超过maxIovecs时分多次写入，socket缓冲区已满时返回已写入的字节数以及EAGAIN
+func writeBuffers(fd int, buffers [][]byte) (int, error) {
+	total := 0
+	for len(buffers) > 0 {
+		batch := buffers
+		if len(batch) > maxIovecs {
+			batch = batch[:maxIovecs]
+		}
+		buffers = buffers[len(batch):]
+
+		var n int
+		var err error
+		if len(batch) == 1 {
+			n, err = syscall.Write(fd, batch[0])
+		} else {
+			n, err = writev(fd, batch)
+		}
+		if err != nil {
+			return total, err
+		}
+		total += n
+
+		batchLen := 0
+		for _, b := range batch {
+			batchLen += len(b)
+		}
+		if n < batchLen {
+			return total, syscall.EAGAIN
+		}
+	}
+	return total, nil
+}
+
+// segment 写缓存区中的一段数据，可以是字节数组、文件片段（sendfile）或者管道中的数据（splice）
+type segment struct {
+	bytes  []byte   // 字节数组
+	file   int      // 文件描述符的副本，不是文件片段时为-1
+	offset int64    // 文件片段的偏移量
+	remain int64    // 文件片段或者管道中剩余的字节数
+	pipe   *splicer // 管道，不是管道数据时为nil
+}
+
+// push 将一段数据放入写缓存区并计入outboundLen，文件片段以及管道数据按照剩余的字节数计算，忽略空的字节数组，调用方持有writeLock
+func (c *Conn) push(seg segment) {
+	if seg.pipe == nil && seg.file < 0 && len(seg.bytes) == 0 {
+		return
+	}
+	// 异步发送字节数组时由发送完成事件驱动，不需要监听可写事件
+	if len(c.outbound) == 0 && (c.server.sender == nil || seg.pipe != nil || seg.file >= 0) {
+		c.setWriteInterest(true)
+	}
+	c.outbound = append(c.outbound, seg)
+	if seg.pipe != nil || seg.file >= 0 {
+		c.outboundLen += int(seg.remain)
+	} else {
+		c.outboundLen += len(seg.bytes)
+	}
+}
+
+// enqueue 跳过已经写入的skip个字节，将剩余的数据复制到写缓存区，调用方持有writeLock，
+// 返回是否超过硬限制以及是否变为不可写
+func (c *Conn) enqueue(buffers [][]byte, skip int) (overflow bool, changed bool) {
+	// 剩余的数据合并成一个字节数组，写入时再和写缓存区中的其他数据一起writev
+	l := -skip
+	for _, b := range buffers {
+		l += len(b)
+	}
+	chunk := make([]byte, 0, l)
+	for _, b := range buffers {
+		if skip >= len(b) {
+			skip -= len(b)
+			continue
+		}
+		chunk = append(chunk, b[skip:]...)
+		skip = 0
+	}
+	c.push(segment{bytes: chunk, file: -1})
+	return c.checkOutbound()
+}
+
+// checkOutbound 检查写缓存区是否超过硬限制以及是否变为不可写，调用方持有writeLock
+func (c *Conn) checkOutbound() (overflow bool, changed bool) {
+	options := c.server.options
+	if options.writeBufferLimit > 0 && c.outboundLen > options.writeBufferLimit {
+		return true, false
+	}
+	if c.outboundLen >= options.writeBufferHighWatermark {
+		changed = atomic.CompareAndSwapInt32(&c.unwritable, 0, 1)
+	}
+	return false, changed
+}
+
+// afterEnqueue 释放writeLock之后处理checkOutbound的结果，超过硬限制时关闭连接并返回ErrWriteBufferOverflow
+func (c *Conn) afterEnqueue(overflow, changed bool) error {
+	if overflow {
+		c.CloseWithError(ErrWriteBufferOverflow)
+		return ErrWriteBufferOverflow
+	}
+	if changed {
+		c.onWritabilityChanged(false)
+	}
+	return nil
+}
+
+// flush 可写事件或者异步发送完成事件到来时，按顺序写入写缓存区中的数据，连续的字节数组使用writev合并写入
+func (c *Conn) flush() error {
+	c.writeLock.Lock()
+	if c.isClosed() {
+		c.writeLock.Unlock()
+		return nil
+	}
+
+	err := c.flushOutbound()
+	if err != nil && err != syscall.EAGAIN && err != errSending {
+		c.writeLock.Unlock()
+		return err
+	}
+	if len(c.outbound) == 0 {
+		c.outbound = nil
+		c.setWriteInterest(false)
+	} else if c.server.sender != nil {
+		// 异步发送时，只有socket缓冲区已满（文件片段、管道数据或者发送返回EAGAIN）才需要监听可写事件
+		c.setWriteInterest(err == syscall.EAGAIN)
+	}
+
+	// 调用了CloseAfterFlush，写缓存区写完之后关闭连接
+	if len(c.outbound) == 0 && atomic.LoadInt32(&c.closing) == 1 {
+		c.writeLock.Unlock()
+		c.Close()
+		return nil
+	}
+
+	changed := false
+	if c.outboundLen <= c.server.options.writeBufferLowWatermark {
+		changed = atomic.CompareAndSwapInt32(&c.unwritable, 1, 0)
+	}
+	c.writeLock.Unlock()
+
+	if changed {
+		c.onWritabilityChanged(true)
+	}
+	return nil
+}
+
+// CloseAfterFlush 写缓存区中的数据全部写入之后关闭连接，和Close一样不会回调OnClose，例如写入HTTP响应之后关闭连接，
+// 调用之后不再读取数据，写入返回ErrConnClosed，对端一直不读取时，需要依靠超时关闭连接
+func (c *Conn) CloseAfterFlush() {
+	c.writeLock.Lock()
+	if c.isClosing() {
+		c.writeLock.Unlock()
+		return
+	}
+	if len(c.outbound) == 0 {
+		c.writeLock.Unlock()
+		c.Close()
+		return
+	}
+
+	atomic.StoreInt32(&c.closing, 1)
+	// 持有写锁，flush在写锁中检查closing，此时连接不会被关闭，文件描述符不会被复用
+	err := c.setReadInterest(false)
+	if err != nil {
+		log.Error(err)
+	}
+	c.writeLock.Unlock()
+}
+
+// flushOutbound 按顺序写入写缓存区中的数据，socket缓冲区已满时返回EAGAIN，正在异步发送时返回errSending，调用方持有writeLock
+func (c *Conn) flushOutbound() error {
+	var err error
+	for len(c.outbound) > 0 && err == nil {
+		seg := &c.outbound[0]
+		switch {
+		case seg.pipe != nil:
+			err = c.flushPipe(seg)
+		case seg.file >= 0:
+			err = c.flushFile(seg)
+		case c.server.sender != nil:
+			err = c.sendBytes()
+		default:
+			err = c.flushBytes()
+		}
+	}
+	return err
+}
+
+// headBytes 获取写缓存区头部连续的字节数组，最多maxIovecs个，使用之后需要调用releaseIovecs
+func (c *Conn) headBytes() [][]byte {
+	iovecs := c.iovecs[:0]
+	for i := range c.outbound {
+		if c.outbound[i].pipe != nil || c.outbound[i].file >= 0 || len(iovecs) == maxIovecs {
+			break
+		}
+		iovecs = append(iovecs, c.outbound[i].bytes)
+	}
+	return iovecs
+}
+
+// releaseIovecs 清空iovecs中的引用，复用内存
+func (c *Conn) releaseIovecs(iovecs [][]byte) {
+	for i := range iovecs {
+		iovecs[i] = nil
+	}
+	c.iovecs = iovecs[:0]
+}
+
+// flushBytes 使用writev写入写缓存区头部连续的字节数组，socket缓冲区已满时返回EAGAIN
+func (c *Conn) flushBytes() error {
+	iovecs := c.headBytes()
+	n, err := writeBuffers(int(c.fd), iovecs)
+	c.releaseIovecs(iovecs)
+	c.popBytes(n)
+	return err
+}
+
+// sendBytes 使用netpoll异步发送写缓存区头部连续的字节数组，先处理上次发送的结果，
+// 提交发送之后返回errSending，发送完成之后产生可写事件，再次调用flush
+func (c *Conn) sendBytes() error {
+	n, pending, err := c.server.sender.sent(int(c.fd))
+	c.popBytes(n)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return errSending
+	}
+	// 上次发送之后头部不再是字节数组，按顺序写入文件片段或者管道数据
+	if len(c.outbound) == 0 || c.outbound[0].pipe != nil || c.outbound[0].file >= 0 {
+		return nil
+	}
+
+	iovecs := c.headBytes()
+	err = c.server.sender.send(int(c.fd), iovecs)
+	c.releaseIovecs(iovecs)
+	if err != nil {
+		return err
+	}
+	return errSending
+}
+
+// popBytes 移除写缓存区头部已经写入的n个字节
+func (c *Conn) popBytes(n int) {
+	c.outboundLen -= n
+	// 移除已经写完的字节数组，长度为0的字节数组不论n是多少都移除
+	for len(c.outbound) > 0 {
+		seg := &c.outbound[0]
+		if seg.pipe != nil || seg.file >= 0 {
+			break
+		}
+		if n < len(seg.bytes) {
+			seg.bytes = seg.bytes[n:]
+			break
+		}
+		n -= len(seg.bytes)
+		c.pop()
+	}
+}
+
+// pop 移除写缓存区头部的一段数据
+func (c *Conn) pop() {
+	c.outbound[0] = segment{}
+	c.outbound = c.outbound[1:]
+}
+
+// discardOutbound 连接关闭时丢弃写缓存区，释放文件描述符的副本以及管道，调用方持有writeLock
+func (c *Conn) discardOutbound() {
+	for i := range c.outbound {
+		seg := &c.outbound[i]
+		if seg.file >= 0 {
+			_ = syscall.Close(seg.file)
+		}
+		if seg.pipe != nil {
+			seg.pipe.release()
+		}
+	}
+	c.outbound = nil
+	c.outboundLen = 0
+}
+
+// onWritabilityChanged 回调OnWritabilityChanged，不能持有writeLock，回调中可能会写入数据
+func (c *Conn) onWritabilityChanged(writable bool) {
+	if c.server.writability != nil && !c.isClosed() {
+		c.server.writability.OnWritabilityChanged(c, writable)
+	}
+}
+
+// register 将连接注册到netpoll，注册之前（例如在OnConnect中写入数据）修改的读写事件在注册时生效，
+// 连接已经关闭（例如在OnConnect中调用Close）时不再注册，文件描述符可能已经被新连接复用
+func (c *Conn) register() error {
+	c.pollLock.Lock()
+	defer c.pollLock.Unlock()
+	if c.isClosed() {
+		return nil
+	}
+	err := c.server.netpoll.add(int(c.fd))
+	if err != nil {
+		return err
+	}
+	c.registered = true
+	if !c.pollRead || c.pollWrite {
+		return c.server.netpoll.modify(int(c.fd), c.pollRead, c.pollWrite)
+	}
+	return nil
+}
+
+// setReadInterest 修改是否监听读事件，连接已经关闭时忽略，
+// 在pollLock中检查，close持有pollLock关闭文件描述符，防止修改复用该文件描述符的新连接
+func (c *Conn) setReadInterest(read bool) error {
+	c.pollLock.Lock()
+	defer c.pollLock.Unlock()
+	c.pollRead = read
+	if !c.registered || c.isClosed() {
+		return nil
+	}
+	return c.server.netpoll.modify(int(c.fd), c.pollRead, c.pollWrite)
+}
+
+// setDirect 切换为直接读取socket，并且等待尚未完成的异步发送，Splice以及连接移交需要，
+// 返回netpoll中是否没有已经接收尚未读取的数据（io_uring）
+func (c *Conn) setDirect() bool {
+	c.pollLock.Lock()
+	defer c.pollLock.Unlock()
+	if !c.registered || c.isClosed() {
+		return true
+	}
+	return c.server.netpoll.direct(int(c.fd))
+}
+
+// setWriteInterest 修改是否监听写事件，连接已经关闭时忽略
+func (c *Conn) setWriteInterest(write bool) {
+	c.pollLock.Lock()
+	defer c.pollLock.Unlock()
+	c.pollWrite = write
+	if !c.registered || c.isClosed() {
+		return
+	}
+	err := c.server.netpoll.modify(int(c.fd), c.pollRead, c.pollWrite)
+	if err != nil {
+		log.Error(err)
+	}
+}
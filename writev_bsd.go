@@ -0,0 +1,27 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gn
+
+import (
+	"syscall"
+)
+
+// writev 依次写入多个字节数组，socket缓冲区已满时返回已写入的字节数
+func writev(fd int, buffers [][]byte) (int, error) {
+	total := 0
+	for _, b := range buffers {
+		n, err := syscall.Write(fd, b)
+		if err != nil {
+			if total > 0 && err == syscall.EAGAIN {
+				return total, nil
+			}
+			return total, err
+		}
+		total += n
+		if n < len(b) {
+			break
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,10 @@
+package gn
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// writev 使用writev(2)一次写入多个字节数组
+func writev(fd int, buffers [][]byte) (int, error) {
+	return unix.Writev(fd, buffers)
+}